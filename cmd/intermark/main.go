@@ -5,6 +5,7 @@ import (
 	"intermark/internal/app"
 	"intermark/internal/database"
 	"intermark/internal/utils"
+	"os"
 )
 
 func startup() {
@@ -13,6 +14,24 @@ func startup() {
 		return
 	}
 
+	// intermark ids <dir>, run in the content repository, doesn't need the config
+	if len(os.Args) > 1 && os.Args[1] == "ids" {
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: intermark ids <content dir>")
+			return
+		}
+		changes, err := database.AssignIDs(os.Args[2])
+		if err != nil {
+			fmt.Println("Error assigning ids:", err)
+			os.Exit(1)
+		}
+		for _, change := range changes {
+			fmt.Printf("%-10s %s %s\n", change.Change, change.ID, change.Path)
+		}
+		fmt.Printf("%d changes to ids\n", len(changes))
+		return
+	}
+
 	if !utils.Config.Load() {
 		fmt.Println("Generated default config file")
 		return
@@ -20,7 +39,34 @@ func startup() {
 
 	utils.InitLogger()
 
-	if !utils.GitInstalled() {
+	// -pin <commit or tag> deploys the given commit instead of the latest one on the branch, for this run only
+	if pin, ok := utils.ArgValue("-pin"); ok {
+		database.PinOverride = pin
+	}
+
+	if err := utils.SetGitBackend(utils.Config.ContentRepo.GitBackend); err != nil {
+		fmt.Println("Error in config:", err)
+		return
+	}
+
+	// intermark export <dir>
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: intermark export <dir>")
+			return
+		}
+		utils.InitMarkdownConverter()
+		database.Init()
+		if err := app.Export(os.Args[2]); err != nil {
+			fmt.Println("Error exporting site:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Exported site to", os.Args[2])
+		return
+	}
+
+	// git is only run to clone sources with the exec backend
+	if utils.Config.ContentRepo.GitBackend != utils.GIT_BACKEND_GOGIT && utils.Config.HasRemoteSources() && !utils.GitInstalled() {
 		fmt.Println("Issue with git installation, see logs for details. Make sure git is installed and in your PATH.")
 		return
 	}
@@ -33,6 +79,10 @@ func startup() {
 	utils.InitMarkdownConverter()
 
 	database.Init()
+	database.StartScheduler()
+	database.StartUpdateWorker()
+	database.StartPoller()
+	database.StartWatcher()
 
 	app.ServerInstance.Start()
 }
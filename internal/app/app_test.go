@@ -0,0 +1,145 @@
+package app
+
+import (
+	"intermark/internal/database"
+	"intermark/internal/files"
+	"intermark/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+)
+
+var startWorker sync.Once
+
+func init() {
+	blog.Init("", blog.NONE) // consume log messages so they don't block once the buffer fills
+	utils.InitMarkdownConverter()
+}
+
+// setupTestSite runs an update of the given files, by path, from a local content repository in a temporary
+// working directory with the repository's templates and css. Tailwind is replaced with a stub that writes an empty file.
+// Returns the content repository, everything is restored when the test ends.
+func setupTestSite(t *testing.T, contents map[string]string) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(wd, "..", "..")
+
+	// stub npx
+	bin := t.TempDir()
+	stub := "#!/bin/sh\nwhile [ \"$1\" != \"-o\" ]; do shift; done\n: > \"$2\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "npx"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// content
+	repoDir := t.TempDir()
+	runGit(t, repoDir, "init", "-q", "-b", "main")
+	commitTestContent(t, repoDir, contents)
+
+	// working directory
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"templates", "css"} {
+		if err := files.CopyDir(filepath.Join(root, "data", dir), filepath.Join("data", dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := utils.Config
+	utils.Config = utils.ImConfig{Title: "Test", EditPassword: "password"}
+	utils.Config.ContentRepo.URL = repoDir
+	utils.Config.ContentRepo.Branch = "main"
+	utils.Config.ContentRepo.AssetsDir = "assets"
+	t.Cleanup(func() {
+		database.Close()
+		database.DB = nil
+		utils.Config = config
+		os.Chdir(wd)
+	})
+	database.DB = nil
+	database.Init()
+	startWorker.Do(database.StartUpdateWorker)
+	// updates queued by the test must finish before the database is closed
+	t.Cleanup(func() { waitForUpdate(t) })
+	if job := waitForUpdate(t); job.Status != database.JOB_DONE {
+		t.Fatalf("update failed: %s", job.Error)
+	}
+	return repoDir
+}
+
+// waitForUpdate queues an update and waits for it to finish, so earlier ones have finished too.
+func waitForUpdate(t *testing.T) database.UpdateJob {
+	t.Helper()
+	job, err := database.QueueUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		job, _ = database.GetUpdateJob(job.ID)
+		if job.Status == database.JOB_FAILED || job.Status == database.JOB_DONE {
+			return job
+		}
+	}
+	t.Fatal("update timed out")
+	return job
+}
+
+// commitTestContent writes the files, by path, to the repository along with the ids.json of its pages and commits them.
+func commitTestContent(t *testing.T, repoDir string, contents map[string]string) {
+	t.Helper()
+	ids := make(map[string]string)
+	if _, err := files.LoadJSON(filepath.Join(repoDir, ".github", "ids.json"), &ids); err != nil {
+		t.Fatal(err)
+	}
+	for relPath, content := range contents {
+		path := filepath.Join(repoDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := files.CreateFile(path, content); err != nil {
+			t.Fatal(err)
+		}
+		firstLine, _, _ := strings.Cut(content, "\n")
+		if id, ok := strings.CutPrefix(firstLine, "<!-- ID: "); ok && strings.HasSuffix(relPath, ".md") {
+			ids[strings.TrimSuffix(id, " -->")] = relPath
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(repoDir, ".github"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := files.SaveJSON(filepath.Join(repoDir, ".github", "ids.json"), ids, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoDir, "add", "-A")
+	runGit(t, repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "update")
+}
+
+// runGit runs git with the given arguments in dir.
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, output)
+	}
+}
+
+// getRoute requests the route from the router as if sent to example.com.
+func getRoute(handler http.Handler, route string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com"+route, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
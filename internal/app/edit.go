@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"html/template"
 	"intermark/internal/database"
@@ -37,25 +38,64 @@ type sandboxReq struct {
 	} `json:"data"`
 }
 
-var (
-	rateLimitMutex   sync.Mutex
-	editSessionMutex sync.Mutex
-	editSessionToken string = "" // empty = no session
-)
+type userReq struct {
+	Token string `json:"token"`
+	Data  struct {
+		ID       uint    `json:"id"`
+		Username string  `json:"username"`
+		Password *string `json:"password"` // nil keeps the password when updating
+		Role     string  `json:"role"`
+	} `json:"data"`
+}
+
+type pinReq struct {
+	Token string `json:"token"`
+	Data  struct {
+		Ref string `json:"ref"` // commit hash or tag, empty to follow the branch
+	} `json:"data"`
+}
+
+type revisionReq struct {
+	Token string `json:"token"`
+	Data  struct {
+		ID   uint `json:"id"`
+		From uint `json:"from"` // 0 = current layout
+		To   uint `json:"to"`   // 0 = current layout
+	} `json:"data"`
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+var rateLimitMutex sync.Mutex
+
+// currentUser returns the user of the edit session, set by EditAuthMiddleware.
+func currentUser(r *http.Request) *database.UserModel {
+	user, _ := r.Context().Value(userContextKey).(*database.UserModel)
+	return user
+}
+
+// sessionUser returns the user of the session cookie, or nil if there isn't a valid one.
+// Only for read only use, e.g. previewing drafts, as it doesn't check the request token.
+func sessionUser(r *http.Request) *database.UserModel {
+	cookie, err := r.Cookie("sessionToken")
+	if err != nil {
+		return nil
+	}
+	user, err := database.GetSessionUser(cookie.Value)
+	if err != nil {
+		blog.Errorf("Error getting session user: %v", err)
+		return nil
+	}
+	return user
+}
 
 func EditAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		editSessionMutex.Lock()
-		// if no edit session token, redirect to login
-		if editSessionToken == "" {
-			editSessionMutex.Unlock()
-			http.Redirect(w, r, "/edit", http.StatusSeeOther)
-			return
-		}
 		// read the body
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			editSessionMutex.Unlock()
 			http.Error(w, "Error reading request body", http.StatusBadRequest)
 			return
 		}
@@ -65,31 +105,49 @@ func EditAuthMiddleware(next http.Handler) http.Handler {
 		// parse the body
 		var req genericRequest
 		if err := json.Unmarshal(bodyBytes, &req); err != nil {
-			editSessionMutex.Unlock()
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
 		// get sessionToken cookie
 		cookie, err := r.Cookie("sessionToken")
 		if err != nil {
-			editSessionMutex.Unlock()
 			http.Error(w, "No session token", http.StatusUnauthorized)
 			return
 		}
-		// check if token or cookie is invalid
-		if (req.Token != editSessionToken) || (cookie.Value != editSessionToken) {
-			editSessionMutex.Unlock()
+		// check if token and cookie match, then look up the session
+		if req.Token != cookie.Value {
 			http.Error(w, "Invalid token or cookie", http.StatusUnauthorized)
 			return
 		}
-		editSessionMutex.Unlock()
-		next.ServeHTTP(w, r)
+		user, err := database.GetSessionUser(cookie.Value)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
 	})
 }
 
+// RequireRole rejects requests from users without the given role. Must be used after EditAuthMiddleware.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user := currentUser(r); (user == nil) || !database.HasRole(user.Role, role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func GetEditLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		data := map[string]interface{}{"Title": utils.Config.Title, "Layout": database.GetLayout(), "Hamburger": false, "Edit": false}
+		data := map[string]interface{}{"Title": utils.Config.Title, "Layout": database.GetPublicLayout(), "Hamburger": false, "Edit": false}
 		if err := Templates.ExecuteTemplate(w, "editLogin.html", data); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -104,6 +162,7 @@ func PostEditLogin(usingTLS *bool) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		username := r.FormValue("username")
 		password := r.FormValue("password")
 		// rate limit
 		rateLimitMutex.Lock()
@@ -111,25 +170,34 @@ func PostEditLogin(usingTLS *bool) http.HandlerFunc {
 			time.Sleep(12 * time.Second)
 			rateLimitMutex.Unlock()
 		}()
-		// check password
-		if password != utils.Config.EditPassword {
-			http.Error(w, "Invalid password", http.StatusUnauthorized)
+		// check username and password
+		user, err := database.Authenticate(username, password)
+		if err != nil {
+			blog.Errorf("Error authenticating '%s': %v", username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 			return
 		}
-		// gen new token
-		editSessionMutex.Lock()
-		defer editSessionMutex.Unlock()
-		newToken, err := utils.GenRandomString(32)
+		// create a new session
+		maxAge := time.Duration(utils.Config.SessionMaxAge) * time.Second
+		if maxAge <= 0 {
+			maxAge = 24 * time.Hour
+		}
+		newToken, err := database.CreateSession(user.ID, maxAge)
 		if err != nil {
+			blog.Errorf("Error creating session for '%s': %v", username, err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		editSessionToken = newToken
 		// set cookie
 		http.SetCookie(w, &http.Cookie{
 			Name:     "sessionToken",
 			Value:    newToken,
-			Path:     "/edit",
+			Path:     "/", // sent with page requests too so drafts can be previewed
+			MaxAge:   int(maxAge.Seconds()),
 			Secure:   *usingTLS,
 			HttpOnly: true,
 			SameSite: http.SameSiteStrictMode,
@@ -154,23 +222,22 @@ func PostEditLogin(usingTLS *bool) http.HandlerFunc {
 			return
 		}
 
-		// init sandbox
-		var sandboxHTML string
-		if sandboxHTML, err = database.UpdateSandbox(database.DEFAULT_SANDBOX_MD); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		// show the sandbox as the last editor left it
+		sandboxMD, sandboxHTML := database.GetSandbox()
 
 		// render edit page, embed token
 		data := map[string]interface{}{
 			"Token":            newToken,
+			"User":             user,
+			"CanEdit":          database.HasRole(user.Role, database.ROLE_EDITOR),
+			"IsAdmin":          database.HasRole(user.Role, database.ROLE_ADMIN),
 			"Title":            utils.Config.Title,
 			"Layout":           *layout,
 			"PageMetaDataJSON": template.JS(metaBytes),
 			"UpdateTimeout":    utils.Config.UpdateTimeout * 1000,
 			"Hamburger":        true,
 			"Edit":             true,
-			"SandboxMD":        database.DEFAULT_SANDBOX_MD,
+			"SandboxMD":        sandboxMD,
 			"SandboxHTML":      template.HTML(sandboxHTML),
 		}
 		if err := Templates.ExecuteTemplate(w, "edit.html", data); err != nil {
@@ -228,16 +295,18 @@ func PostEditUpdateSandbox() http.HandlerFunc {
 	}
 }
 
-// PostEditUpdateContent
+// PostEditUpdateContent queues a content update and responds with the job, see GetUpdateStatus.
 func PostEditUpdateContent() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var err error
-		if err = database.Update(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-		// get new meta data for all pages and return it
-		var metaDatas []database.ContentMeta
-		if metaDatas, err = database.GetMeta(); err != nil {
+		queueUpdate(w)
+	}
+}
+
+// PostEditMeta returns the meta data of all pages as JSON.
+func PostEditMeta() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metaDatas, err := database.GetMeta()
+		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -261,17 +330,174 @@ func PostEditSave() http.HandlerFunc {
 		blog.Debugf("New Layout: %v", saveReq.Data.Layout)
 		database.UpdateMutex.Lock() // avoid writing a new layout while the database is updating
 		defer database.UpdateMutex.Unlock()
-		database.SetLayout(&saveReq.Data.Layout)
+		if err := database.SetLayout(&saveReq.Data.Layout, currentUser(r).Username); err != nil {
+			blog.Errorf("Error saving layout: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 	}
 }
 
 // PostEditExit
 func PostEditExit() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		blog.Debug("Exiting edit session")
-		editSessionMutex.Lock()
-		editSessionToken = ""
-		editSessionMutex.Unlock()
+		blog.Debugf("Exiting edit session of '%s'", currentUser(r).Username)
+		if cookie, err := r.Cookie("sessionToken"); err == nil {
+			if err := database.DeleteSession(cookie.Value); err != nil {
+				blog.Errorf("Error deleting session: %v", err)
+			}
+		}
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
+
+// PostEditUsers returns all users as JSON.
+func PostEditUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := database.GetUsers()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	}
+}
+
+// PostEditUsersCreate creates a new user.
+func PostEditUsersCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req userReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := database.CreateUser(req.Data.Username, utils.Ternary(req.Data.Password == nil, "", *req.Data.Password), req.Data.Role); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		blog.Infof("User '%s' created by '%s'", req.Data.Username, currentUser(r).Username)
+	}
+}
+
+// PostEditUsersUpdate sets the role and optionally the password of a user.
+func PostEditUsersUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req userReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := database.UpdateUser(req.Data.ID, req.Data.Password, req.Data.Role); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		blog.Infof("User %d updated by '%s'", req.Data.ID, currentUser(r).Username)
+	}
+}
+
+// PostEditUsersDelete deletes a user.
+func PostEditUsersDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req userReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := database.DeleteUser(req.Data.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		blog.Infof("User %d deleted by '%s'", req.Data.ID, currentUser(r).Username)
+	}
+}
+
+// PostEditReport returns the report of the most recent content update as JSON, null if there hasn't been one.
+func PostEditReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := database.GetLatestUpdateReport()
+		if err != nil {
+			blog.Errorf("Error getting update report: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// PostEditRevisions returns the layout revision history as JSON, newest first.
+func PostEditRevisions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revisions, err := database.GetLayoutRevisions()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revisions)
+	}
+}
+
+// PostEditRevisionsDiff returns the structural changes between two layout revisions as JSON.
+func PostEditRevisionsDiff() http.HandlerFunc {
+	getLayout := func(id uint) (*database.Layout, error) {
+		if id == 0 {
+			return database.GetLayoutDB()
+		}
+		return database.GetLayoutRevision(id)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req revisionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, err := getLayout(req.Data.From)
+		if err != nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		to, err := getLayout(req.Data.To)
+		if err != nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(database.DiffLayouts(from, to))
+	}
+}
+
+// PostEditRevisionsRestore makes the given revision the current layout.
+// Responds with the rendered edit sidebar and footer items so the editor can swap them in.
+func PostEditRevisionsRestore() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req revisionReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		database.UpdateMutex.Lock() // avoid writing a new layout while the database is updating
+		layout, err := database.RestoreLayoutRevision(req.Data.ID, currentUser(r).Username)
+		database.UpdateMutex.Unlock()
+		if err != nil {
+			blog.Errorf("Error restoring layout revision %d: %v", req.Data.ID, err)
+			http.Error(w, "Error restoring revision", http.StatusInternalServerError)
+			return
+		}
+		var sidebar, footer bytes.Buffer
+		for _, item := range layout.Sidebar {
+			if err := Templates.ExecuteTemplate(&sidebar, "edit_sidebar_item", item); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		for _, item := range layout.Footer {
+			if err := Templates.ExecuteTemplate(&footer, "edit_footer_item", item); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"Sidebar": sidebar.String(), "Footer": footer.String(), "Landing": layout.Landing})
+	}
+}
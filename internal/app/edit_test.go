@@ -0,0 +1,158 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"intermark/internal/database"
+	"intermark/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSession creates a user with the given role and returns a session token for it.
+func testSession(t *testing.T, username, role string) string {
+	t.Helper()
+	user, err := database.CreateUser(username, "password", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := database.CreateSession(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// editRequest posts data to an edit route with the session cookie and the request token.
+func editRequest(handler http.Handler, route, cookie, token string, data interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"token": token, "data": data})
+	r := httptest.NewRequest(http.MethodPost, route, bytes.NewReader(body))
+	if cookie != "" {
+		r.AddCookie(&http.Cookie{Name: "sessionToken", Value: cookie})
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestEditAuth(t *testing.T) {
+	setupTestSite(t, map[string]string{"home.md": "<!-- ID: home -->\n# Home\n"})
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+	viewer := testSession(t, "viewer", database.ROLE_VIEWER)
+	editor := testSession(t, "editor", database.ROLE_EDITOR)
+
+	sandbox := map[string]string{"sandbox_md": "# Shared sandbox"}
+	tests := []struct {
+		route, cookie, token string
+		data                 interface{}
+		want                 int
+	}{
+		{"/edit/exit", "", viewer, nil, http.StatusUnauthorized},
+		{"/edit/exit", viewer, editor, nil, http.StatusUnauthorized},
+		{"/edit/exit", "unknown", "unknown", nil, http.StatusUnauthorized},
+		{"/edit/update-sandbox", viewer, viewer, sandbox, http.StatusForbidden},
+		{"/edit/users", editor, editor, nil, http.StatusForbidden},
+		{"/edit/update-sandbox", editor, editor, sandbox, http.StatusOK},
+		{"/edit/report", viewer, viewer, nil, http.StatusOK},
+		{"/edit/exit", viewer, viewer, nil, http.StatusSeeOther},
+	}
+	for _, test := range tests {
+		if w := editRequest(router, test.route, test.cookie, test.token, test.data); w.Code != test.want {
+			t.Errorf("%s as %s = %d, want %d", test.route, test.cookie, w.Code, test.want)
+		}
+	}
+
+	// logging in shows the sandbox as it was left instead of resetting it
+	form := url.Values{"username": {"admin"}, "password": {"password"}}
+	r := httptest.NewRequest(http.MethodPost, "/edit", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "# Shared sandbox") {
+		t.Error("login reset the sandbox")
+	}
+	if md, _ := database.GetSandbox(); md != "# Shared sandbox" {
+		t.Errorf("sandbox = %q", md)
+	}
+}
+
+func TestEditUsers(t *testing.T) {
+	setupTestSite(t, map[string]string{"home.md": "<!-- ID: home -->\n# Home\n"})
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+	admin := testSession(t, "root", database.ROLE_ADMIN)
+
+	create := map[string]interface{}{"username": "bob", "password": "", "role": database.ROLE_EDITOR}
+	if w := editRequest(router, "/edit/users/create", admin, admin, create); w.Code != http.StatusBadRequest {
+		t.Errorf("create with an empty password = %d", w.Code)
+	}
+	create["password"] = "secret"
+	if w := editRequest(router, "/edit/users/create", admin, admin, create); w.Code != http.StatusOK {
+		t.Fatalf("create = %d: %s", w.Code, w.Body.String())
+	}
+	bob, err := database.Authenticate("bob", "secret")
+	if err != nil || bob == nil {
+		t.Fatalf("bob not created: %v", err)
+	}
+
+	// a missing password keeps it, an empty one is rejected
+	update := map[string]interface{}{"id": bob.ID, "role": database.ROLE_VIEWER}
+	if w := editRequest(router, "/edit/users/update", admin, admin, update); w.Code != http.StatusOK {
+		t.Fatalf("role update = %d: %s", w.Code, w.Body.String())
+	}
+	update["password"] = ""
+	if w := editRequest(router, "/edit/users/update", admin, admin, update); w.Code != http.StatusBadRequest {
+		t.Errorf("update with an empty password = %d", w.Code)
+	}
+	if user, _ := database.Authenticate("bob", "secret"); user == nil || user.Role != database.ROLE_VIEWER {
+		t.Errorf("bob = %+v", user)
+	}
+}
+
+func TestEditPin(t *testing.T) {
+	repoDir := setupTestSite(t, map[string]string{"home.md": "<!-- ID: home -->\n# Before\n"})
+	runGit(t, repoDir, "tag", "v1")
+	commitTestContent(t, repoDir, map[string]string{"home.md": "<!-- ID: home -->\n# After\n"})
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+	admin := testSession(t, "root", database.ROLE_ADMIN)
+	t.Cleanup(func() { database.SetPin("", "root") })
+
+	for _, ref := range []string{"-v1", "missing"} {
+		if w := editRequest(router, "/edit/pin", admin, admin, map[string]string{"ref": ref}); w.Code != http.StatusBadRequest {
+			t.Errorf("pin %q = %d", ref, w.Code)
+		}
+	}
+	if w := editRequest(router, "/edit/pin", admin, admin, map[string]string{"ref": "v1"}); w.Code != http.StatusAccepted {
+		t.Fatalf("pin = %d: %s", w.Code, w.Body.String())
+	}
+	waitForUpdate(t)
+	if content, err := database.GetContent("home"); err != nil || !strings.Contains(content.HTML, "Before") {
+		t.Errorf("pinned content = %+v, %v", content, err)
+	}
+
+	// the pin is stored in the database, the config is left alone
+	if utils.Config.ContentRepo.Pin != "" {
+		t.Errorf("config pin = %q", utils.Config.ContentRepo.Pin)
+	}
+	if _, err := os.Stat(utils.ConfigPath); !os.IsNotExist(err) {
+		t.Errorf("config saved: %v", err)
+	}
+
+	if w := editRequest(router, "/edit/pin", admin, admin, map[string]string{"ref": ""}); w.Code != http.StatusAccepted {
+		t.Fatalf("unpin = %d: %s", w.Code, w.Body.String())
+	}
+	waitForUpdate(t)
+	if content, err := database.GetContent("home"); err != nil || !strings.Contains(content.HTML, "After") {
+		t.Errorf("unpinned content = %+v, %v", content, err)
+	}
+}
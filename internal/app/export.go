@@ -0,0 +1,160 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"intermark/internal/database"
+	"intermark/internal/files"
+	"intermark/internal/utils"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// exportMarker is written to the root of every export, only directories that are empty or contain it are cleaned.
+const exportMarker = ".intermark-export"
+
+var pageLinkRegex = regexp.MustCompile(`href="(/page\?id=[^"#]+|/p/[^"#?]+)(#[^"]*)?"`)
+
+// Export renders every page and copies the assets and css into dir, producing a static site that can be hosted without the server.
+// The result expects to be served from the root of a domain.
+func Export(dir string) error {
+	if err := prepareExportDir(dir); err != nil {
+		return err
+	}
+	if err := LoadTemplates(); err != nil {
+		return fmt.Errorf("error parsing templates: %w", err)
+	}
+
+	metaDatas, err := database.GetMeta()
+	if err != nil {
+		return err
+	}
+	// link pages by their static paths, e.g. "/p/guides/setup.html"
+	tmpl, err := Templates.Clone()
+	if err != nil {
+		return err
+	}
+	tmpl.Funcs(template.FuncMap{"pageURL": staticURL})
+
+	// pages
+	landingID := database.GetLayout().Landing.ID
+	if err := exportPage(tmpl, landingID, "landing.html", filepath.Join(dir, "index.html")); err != nil {
+		return err
+	}
+	for _, metaData := range metaDatas {
+		if !database.IsPublished(metaData.ID) {
+			continue
+		}
+		url := staticURL(metaData.ID)
+		if err := exportPage(tmpl, metaData.ID, "page.html", filepath.Join(dir, filepath.FromSlash(url))); err != nil {
+			return err
+		}
+	}
+	if err := files.CreateFile(filepath.Join(dir, "404.html"), "404 - Not Found"); err != nil {
+		return err
+	}
+
+	// assets and favicon
+	database.AssetsMutex.RLock()
+	defer database.AssetsMutex.RUnlock()
+	for _, source := range utils.Config.ContentSources() {
+		assetsDir := filepath.Join(filepath.FromSlash(source.Mount), source.AssetsDir)
+		if exists, err := files.Exists(filepath.Join("data", "assets", assetsDir)); err != nil {
+			return err
+		} else if exists {
+			if err := files.CopyDir(filepath.Join("data", "assets", assetsDir), filepath.Join(dir, assetsDir)); err != nil {
+				return err
+			}
+		}
+	}
+	logo := filepath.Join("data", "assets", utils.Config.ContentRepo.AssetsDir, "logo.svg")
+	if exists, err := files.Exists(logo); err != nil {
+		return err
+	} else if exists {
+		if err := files.CopyFile(logo, filepath.Join(dir, "favicon.ico")); err != nil {
+			return err
+		}
+	}
+
+	// css, everything but the tailwind input
+	cssFiles, err := files.ListFiles(filepath.Join("data", "css"), true)
+	if err != nil {
+		return err
+	}
+	for _, name := range cssFiles {
+		if name == "app.css" {
+			continue
+		}
+		if err := files.CopyFile(filepath.Join("data", "css", name), filepath.Join(dir, "css", name)); err != nil {
+			return err
+		}
+	}
+
+	blog.Infof("Exported %d pages to %s", len(metaDatas), dir)
+	return nil
+}
+
+// prepareExportDir creates or cleans the export directory. Refuses to clean non empty directories that aren't a previous export.
+func prepareExportDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) != 0 {
+		if exists, err := files.Exists(filepath.Join(dir, exportMarker)); err != nil {
+			return err
+		} else if !exists {
+			return fmt.Errorf("'%s' is not empty and is not a previous export", dir)
+		}
+	}
+	if err := files.CleanDir(dir); err != nil {
+		return err
+	}
+	return files.CreateFile(filepath.Join(dir, exportMarker), "")
+}
+
+// exportPage renders the page with the given id and writes it to dst, rewriting links to other pages to their static paths.
+func exportPage(tmpl *template.Template, id, templateName, dst string) error {
+	content, err := database.GetContent(id)
+	if err != nil {
+		return err
+	}
+	if !content.Published {
+		content = database.NotFoundContent()
+	}
+	data := pageData(content)
+	data["Static"] = true
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, pageTemplate(tmpl, content, templateName), data); err != nil {
+		return err
+	}
+	out := pageLinkRegex.ReplaceAllStringFunc(buf.String(), func(match string) string {
+		groups := pageLinkRegex.FindStringSubmatch(match)
+		link, fragment := groups[1], groups[2] // the fragment stays after the .html
+		if strings.HasPrefix(link, "/page?id=") {
+			return `href="` + staticURL(strings.TrimPrefix(link, "/page?id=")) + fragment + `"`
+		}
+		if strings.HasSuffix(link, ".html") {
+			return match
+		}
+		return `href="` + link + `.html` + fragment + `"`
+	})
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return files.CreateFile(dst, out)
+}
+
+// staticURL returns the static path of the page with the given id.
+func staticURL(id string) string {
+	url := database.PageURL(id)
+	if strings.HasPrefix(url, "/page?id=") {
+		return "/page/" + id + ".html"
+	}
+	return url + ".html"
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"intermark/internal/database"
+	"intermark/internal/files"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	setupTestSite(t, map[string]string{
+		"home.md":             "<!-- ID: home -->\n# Home\nSee [setup](/page?id=setup) and [the draft](id:draft).\n",
+		"guides/Setup.md":     "<!-- ID: setup -->\n# Setup\nBack [home](/p/home), [intro](id:home#intro), or [the top](/page?id=home#top).\n",
+		"draft.md":            "<!-- ID: draft -->\n---\ndraft: true\n---\n# Draft\n",
+		"assets/logo.svg":     "<svg></svg>",
+		"assets/img/logo.png": "png",
+	})
+	layout := database.GetLayout()
+	layout.Landing = database.ContentMeta{ID: "home", RelPath: "home.md"}
+	if err := database.SetLayout(&layout, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "site")
+	if err := Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{exportMarker, "index.html", "p/home.html", "p/guides/setup.html", "404.html", "favicon.ico", "assets/img/logo.png", "css/ProggyVector.ttf"} {
+		if exists, err := files.Exists(filepath.Join(dir, name)); err != nil || !exists {
+			t.Errorf("%s not exported", name)
+		}
+	}
+	for _, name := range []string{"p/draft.html", "css/app.css"} {
+		if exists, _ := files.Exists(filepath.Join(dir, name)); exists {
+			t.Errorf("%s exported", name)
+		}
+	}
+
+	index, err := files.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(index, `href="/p/guides/setup.html"`) {
+		t.Errorf("index.html doesn't link to the static setup page")
+	}
+	if strings.Contains(index, "/feed.xml") {
+		t.Errorf("index.html links to a feed that isn't exported")
+	}
+	setup, err := files.ReadFile(filepath.Join(dir, "p", "guides", "setup.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(setup, `href="/p/home.html"`) || strings.Contains(setup, "/page?id=") {
+		t.Errorf("setup.html links weren't rewritten")
+	}
+	if !strings.Contains(setup, `href="/p/home.html#intro"`) || !strings.Contains(setup, `href="/p/home.html#top"`) {
+		t.Errorf("setup.html links to headings weren't rewritten")
+	}
+
+	// exporting again replaces the previous export
+	if err := os.WriteFile(filepath.Join(dir, "stale.html"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Export(dir); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := files.Exists(filepath.Join(dir, "stale.html")); exists {
+		t.Errorf("stale.html not removed")
+	}
+}
+
+func TestPrepareExportDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "important.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareExportDir(dir); err == nil {
+		t.Error("prepareExportDir cleaned a directory that isn't an export")
+	}
+	if exists, _ := files.Exists(filepath.Join(dir, "important.txt")); !exists {
+		t.Error("important.txt removed")
+	}
+
+	missing := filepath.Join(t.TempDir(), "new")
+	if err := prepareExportDir(missing); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := files.Exists(filepath.Join(missing, exportMarker)); !exists {
+		t.Error("marker not written")
+	}
+}
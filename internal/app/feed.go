@@ -0,0 +1,215 @@
+package app
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"intermark/internal/database"
+	"intermark/internal/utils"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+)
+
+var rootLinkRegex = regexp.MustCompile(`(href|src)="/([^/])`)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+// rssGUID identifies an item by its page id, so it doesn't change when the page moves.
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	ContentHTML   string `json:"content_html"`
+	DateModified  string `json:"date_modified"`
+	DatePublished string `json:"date_published"`
+}
+
+// siteURL returns the public url of the site without a trailing slash.
+// Uses the configured site_url if set, otherwise it's derived from the request, and the response is marked as not
+// cacheable so a cache doesn't serve links built from one request's host to everyone.
+func siteURL(w http.ResponseWriter, r *http.Request) string {
+	if utils.Config.SiteURL != "" {
+		return strings.TrimSuffix(utils.Config.SiteURL, "/")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); utils.Config.Server.TrustProxy && proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// recentContent returns the pages for a feed, newest first, and the time of the latest change.
+func recentContent(w http.ResponseWriter) ([]database.ContentModel, time.Time, bool) {
+	contents, err := database.GetRecentContent(utils.Config.FeedSize)
+	if err != nil {
+		blog.Errorf("Error getting recent content: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, time.Time{}, false
+	}
+	var latest time.Time
+	if len(contents) > 0 {
+		latest = contents[0].Updated
+	}
+	return contents, latest, true
+}
+
+// GetRSSFeed serves an RSS 2.0 feed of recently changed pages.
+func GetRSSFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		contents, latest, ok := recentContent(w)
+		if !ok {
+			return
+		}
+		base := siteURL(w, r)
+		feed := rssFeed{Version: "2.0", Channel: rssChannel{Title: utils.Config.Title, Link: base + "/", Description: utils.Config.Title}}
+		if !latest.IsZero() {
+			feed.Channel.LastBuildDate = latest.Format(time.RFC1123Z)
+		}
+		for _, content := range contents {
+			link := base + database.PageURL(content.ID)
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       content.DisplayTitle(),
+				Link:        link,
+				GUID:        rssGUID{IsPermaLink: "false", Value: base + "/page?id=" + content.ID},
+				PubDate:     content.Updated.Format(time.RFC1123Z),
+				Description: absoluteLinks(content.HTML, base),
+			})
+		}
+		writeXML(w, "application/rss+xml", feed)
+	}
+}
+
+// GetAtomFeed serves an Atom feed of recently changed pages.
+func GetAtomFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		contents, latest, ok := recentContent(w)
+		if !ok {
+			return
+		}
+		base := siteURL(w, r)
+		if latest.IsZero() {
+			latest = time.Now() // required, there are no pages to take it from
+		}
+		feed := atomFeed{
+			Title:   utils.Config.Title,
+			ID:      base + "/",
+			Updated: latest.Format(time.RFC3339),
+			Links:   []atomLink{{Href: base + "/"}, {Href: base + "/atom.xml", Rel: "self"}},
+		}
+		for _, content := range contents {
+			link := base + database.PageURL(content.ID)
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   content.DisplayTitle(),
+				ID:      base + "/page?id=" + content.ID,
+				Updated: content.Updated.Format(time.RFC3339),
+				Link:    atomLink{Href: link},
+				Content: atomContent{Type: "html", Body: absoluteLinks(content.HTML, base)},
+			})
+		}
+		writeXML(w, "application/atom+xml", feed)
+	}
+}
+
+// GetJSONFeed serves a JSON Feed (https://jsonfeed.org/version/1.1) of recently changed pages.
+func GetJSONFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		contents, _, ok := recentContent(w)
+		if !ok {
+			return
+		}
+		base := siteURL(w, r)
+		feed := jsonFeed{Version: "https://jsonfeed.org/version/1.1", Title: utils.Config.Title, HomePageURL: base + "/", FeedURL: base + "/feed.json", Items: []jsonFeedItem{}}
+		for _, content := range contents {
+			feed.Items = append(feed.Items, jsonFeedItem{
+				ID:            base + "/page?id=" + content.ID,
+				URL:           base + database.PageURL(content.ID),
+				Title:         content.DisplayTitle(),
+				ContentHTML:   absoluteLinks(content.HTML, base),
+				DateModified:  content.Updated.Format(time.RFC3339),
+				DatePublished: content.Updated.Format(time.RFC3339),
+			})
+		}
+		w.Header().Set("Content-Type", "application/feed+json")
+		if err := json.NewEncoder(w).Encode(feed); err != nil {
+			blog.Errorf("Error encoding json feed: %v", err)
+		}
+	}
+}
+
+// absoluteLinks prefixes root relative links and sources with the site url so they work in feed readers.
+func absoluteLinks(html, base string) string {
+	return rootLinkRegex.ReplaceAllString(html, `$1="`+base+`/$2`)
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		blog.Errorf("Error encoding feed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
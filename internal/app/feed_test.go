@@ -0,0 +1,106 @@
+package app
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"intermark/internal/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeeds(t *testing.T) {
+	setupTestSite(t, map[string]string{
+		"guides/Setup.md": "<!-- ID: setup -->\n# Setup\n![logo](/assets/logo.png) [home](/p/home)\n",
+		"Other Page.md":   "<!-- ID: other -->\nBody\n",
+		"titled.md":       "<!-- ID: titled -->\n---\ntitle: Titled Page\n---\nBody\n",
+		"draft.md":        "<!-- ID: draft -->\n---\ndraft: true\n---\nSecret\n",
+	})
+	utils.Config.Server.CacheMaxAge = 60
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+
+	var rss rssFeed
+	w := getRoute(router, "/feed.xml")
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Channel.Items) != 3 || rss.Channel.Link != "http://example.com/" || rss.Channel.LastBuildDate == "" {
+		t.Errorf("rss = %+v", rss.Channel)
+	}
+	for _, item := range rss.Channel.Items {
+		if item.Title == "Setup" && (item.Link != "http://example.com/p/guides/setup" ||
+			item.GUID.Value != "http://example.com/page?id=setup" || item.GUID.IsPermaLink != "false" ||
+			!strings.Contains(item.Description, `src="http://example.com/assets/logo.png"`) ||
+			!strings.Contains(item.Description, `href="http://example.com/p/home"`)) {
+			t.Errorf("rss item = %+v", item)
+		} else if item.Title != "Setup" && item.Title != "Other Page" && item.Title != "Titled Page" {
+			t.Errorf("unexpected rss item %q", item.Title)
+		}
+	}
+
+	var atom atomFeed
+	if err := xml.Unmarshal(getRoute(router, "/atom.xml").Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if len(atom.Entries) != 3 || atom.ID != "http://example.com/" || !strings.HasPrefix(atom.Entries[0].ID, "http://example.com/page?id=") {
+		t.Errorf("atom = %+v", atom)
+	}
+
+	var feed jsonFeed
+	w = getRoute(router, "/feed.json")
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 3 || feed.FeedURL != "http://example.com/feed.json" || w.Header().Get("Content-Type") != "application/feed+json" {
+		t.Errorf("json feed = %+v", feed)
+	}
+
+	// links from the request host aren't cached, the configured site url is
+	if cache := w.Header().Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("Cache-Control without site_url = %q", cache)
+	}
+	utils.Config.SiteURL = "https://docs.example.org/"
+	w = getRoute(router, "/feed.json")
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.HomePageURL != "https://docs.example.org/" || !strings.HasPrefix(feed.Items[0].URL, "https://docs.example.org/p/") {
+		t.Errorf("json feed with site_url = %+v", feed)
+	}
+	if cache := w.Header().Get("Cache-Control"); cache != "public, max-age=60" {
+		t.Errorf("Cache-Control with site_url = %q", cache)
+	}
+}
+
+func TestEmptyFeeds(t *testing.T) {
+	setupTestSite(t, nil)
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+
+	var atom atomFeed
+	if err := xml.Unmarshal(getRoute(router, "/atom.xml").Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := time.Parse(time.RFC3339, atom.Updated); err != nil || time.Since(updated) > time.Minute {
+		t.Errorf("atom updated = %q, %v", atom.Updated, err)
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(getRoute(router, "/feed.xml").Body.Bytes(), &rss); err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Channel.Items) != 0 || rss.Channel.LastBuildDate != "" {
+		t.Errorf("rss = %+v", rss.Channel)
+	}
+	if body := getRoute(router, "/feed.json").Body.String(); !strings.Contains(body, `"items":[]`) {
+		t.Errorf("json feed = %s", body)
+	}
+}
+
+func TestAbsoluteLinks(t *testing.T) {
+	html := `<a href="/p/x">x</a> <img src="/assets/a.png"> <a href="//cdn.example.com/y">y</a> <a href="https://z.example">z</a>`
+	want := `<a href="https://s.example/p/x">x</a> <img src="https://s.example/assets/a.png"> <a href="//cdn.example.com/y">y</a> <a href="https://z.example">z</a>`
+	if got := absoluteLinks(html, "https://s.example"); got != want {
+		t.Errorf("absoluteLinks() = %s", got)
+	}
+}
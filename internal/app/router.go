@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"intermark/internal/database"
 	"intermark/internal/utils"
-	"io"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
 	"text/template"
@@ -19,6 +19,7 @@ import (
 var (
 	Templates    *template.Template
 	templateGlob = filepath.Join("data", "templates", "*.html")
+	templateFunc = template.FuncMap{"pageURL": database.PageURL}
 )
 
 func logMiddleware(next http.Handler) http.Handler {
@@ -45,13 +46,49 @@ func cacheControlMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// LoadTemplates parses all templates in data/templates.
+func LoadTemplates() error {
+	var err error
+	Templates, err = template.New("").Funcs(templateFunc).ParseGlob(templateGlob)
+	return err
+}
+
+// pageData returns the template data used to render the given page.
+func pageData(content *database.ContentModel) map[string]interface{} {
+	return map[string]interface{}{
+		"Title":       utils.Config.Title,
+		"PageTitle":   content.Front.Title,
+		"Description": content.Front.Description,
+		"Front":       content.Front,
+		"Layout":      database.GetPublicLayout(),
+		"Content":     content.HTML,
+		"TOC":         content.TOC,
+		"ID":          content.ID,
+		"Hamburger":   true,
+		"Edit":        false,
+		"Unpublished": content.ID != "" && !content.Published,
+	}
+}
+
+// pageTemplate returns the template set in the page's front matter, or fallback if it's unset or doesn't exist.
+func pageTemplate(tmpl *template.Template, content *database.ContentModel, fallback string) string {
+	if content.Front.Template == "" {
+		return fallback
+	}
+	name := content.Front.Template + ".html"
+	if tmpl.Lookup(name) == nil {
+		blog.Errorf("Template '%s' of page '%s' not found, using '%s'", name, content.ID, fallback)
+		return fallback
+	}
+	return name
+}
+
 // NewRouter creates and returns a new Chi router.
 func NewRouter(usingTLS *bool) *chi.Mux {
 	r := chi.NewRouter()
 
 	// load the templates
-	var err error
-	if Templates, err = template.ParseGlob(templateGlob); err != nil {
+	if err := LoadTemplates(); err != nil {
 		blog.Fatalf(1, time.Second*3, "Error parsing templates: %s", err)
 	}
 
@@ -64,11 +101,14 @@ func NewRouter(usingTLS *bool) *chi.Mux {
 		r.Get("/css/*", func(w http.ResponseWriter, r *http.Request) { http.ServeFile(w, r, "data"+r.URL.Path) })
 		// If config asset dir is "assets", your src vars will look like "/assets/example.png"
 		// Should mean they still path correctly in the content repo and when served in the site.
-		r.Get(fmt.Sprintf("/%s/*", utils.Config.ContentRepo.AssetsDir), func(w http.ResponseWriter, r *http.Request) {
-			database.AssetsMutex.RLock()
-			defer database.AssetsMutex.RUnlock()
-			http.ServeFile(w, r, filepath.Clean("data/assets"+r.URL.Path))
-		})
+		// Assets of additional sources are under their mount, e.g. "/blog/assets/example.png".
+		for _, source := range utils.Config.ContentSources() {
+			r.Get(fmt.Sprintf("/%s/*", path.Join(source.Mount, source.AssetsDir)), func(w http.ResponseWriter, r *http.Request) {
+				database.AssetsMutex.RLock()
+				defer database.AssetsMutex.RUnlock()
+				http.ServeFile(w, r, filepath.Clean("data/assets"+r.URL.Path))
+			})
+		}
 		r.Get("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 			database.AssetsMutex.RLock()
 			defer database.AssetsMutex.RUnlock()
@@ -77,14 +117,17 @@ func NewRouter(usingTLS *bool) *chi.Mux {
 	})
 
 	// helper func for serving pages
-	var servePage = func(w http.ResponseWriter, id string, template string) {
-		html, err := database.GetHTML(id)
+	var servePage = func(w http.ResponseWriter, r *http.Request, id string, template string) {
+		content, err := database.GetContent(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		data := map[string]interface{}{"Title": utils.Config.Title, "Layout": database.GetLayout(), "Content": html, "Hamburger": true, "Edit": false}
-		if err := Templates.ExecuteTemplate(w, template, data); err != nil {
+		// drafts and scheduled pages are only shown to logged in users
+		if content.ID != "" && !content.Published && sessionUser(r) == nil {
+			content = database.NotFoundContent()
+		}
+		if err := Templates.ExecuteTemplate(w, pageTemplate(Templates, content, template), pageData(content)); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -92,10 +135,36 @@ func NewRouter(usingTLS *bool) *chi.Mux {
 
 	// pages
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		servePage(w, database.GetLayout().Landing.ID, "landing.html")
+		servePage(w, r, database.GetLayout().Landing.ID, "landing.html")
 	})
 	r.Get("/page", func(w http.ResponseWriter, r *http.Request) {
-		servePage(w, r.URL.Query().Get("id"), "page.html")
+		servePage(w, r, r.URL.Query().Get("id"), "page.html")
+	})
+	r.Get("/p/*", func(w http.ResponseWriter, r *http.Request) {
+		id, current, err := database.ResolveSlug(chi.URLParam(r, "*"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if id != "" && !current {
+			http.Redirect(w, r, database.PageURL(id), http.StatusMovedPermanently)
+			return
+		}
+		if id == "" {
+			w.WriteHeader(http.StatusNotFound) // the page still shows the not found message
+		}
+		servePage(w, r, id, "page.html")
+	})
+	r.Get("/search", GetSearch())
+
+	// feeds and crawlers
+	r.Group(func(r chi.Router) {
+		r.Use(cacheControlMiddleware)
+		r.Get("/feed.xml", GetRSSFeed())
+		r.Get("/atom.xml", GetAtomFeed())
+		r.Get("/feed.json", GetJSONFeed())
+		r.Get("/sitemap.xml", GetSitemap())
+		r.Get("/robots.txt", GetRobots())
 	})
 
 	// edit
@@ -103,29 +172,34 @@ func NewRouter(usingTLS *bool) *chi.Mux {
 	r.Post("/edit", PostEditLogin(usingTLS))
 	r.Group(func(r chi.Router) {
 		r.Use(EditAuthMiddleware)
-		r.Post("/edit/new-sidebar-item", PostEditNewSidebarItem())
-		r.Post("/edit/new-footer-item", PostEditNewFooterItem())
-		r.Post("/edit/update-sandbox", PostEditUpdateSandbox())
-		r.Post("/edit/update-content", PostEditUpdateContent())
-		r.Post("/edit/save", PostEditSave())
+		r.Post("/edit/report", PostEditReport())
+		r.Post("/edit/sync", PostEditSync())
+		r.Post("/edit/revisions", PostEditRevisions())
+		r.Post("/edit/revisions/diff", PostEditRevisionsDiff())
 		r.Post("/edit/exit", PostEditExit())
+		r.Group(func(r chi.Router) {
+			r.Use(RequireRole(database.ROLE_EDITOR))
+			r.Post("/edit/update-sandbox", PostEditUpdateSandbox())
+			r.Post("/edit/new-sidebar-item", PostEditNewSidebarItem())
+			r.Post("/edit/new-footer-item", PostEditNewFooterItem())
+			r.Post("/edit/update-content", PostEditUpdateContent())
+			r.Post("/edit/meta", PostEditMeta())
+			r.Post("/edit/save", PostEditSave())
+			r.Post("/edit/revisions/restore", PostEditRevisionsRestore())
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(RequireRole(database.ROLE_ADMIN))
+			r.Post("/edit/users", PostEditUsers())
+			r.Post("/edit/users/create", PostEditUsersCreate())
+			r.Post("/edit/users/update", PostEditUsersUpdate())
+			r.Post("/edit/users/delete", PostEditUsersDelete())
+			r.Post("/edit/pin", PostEditPin())
+		})
 	})
 
 	// update from content repo action
-	r.Post("/update", func(w http.ResponseWriter, r *http.Request) {
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
-			return
-		}
-		if string(body) != utils.Config.UpdateToken {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		if err := database.Update(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	})
+	r.Post("/update", PostUpdate())
+	r.Get("/update/status/{job}", GetUpdateStatus())
 
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
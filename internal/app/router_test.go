@@ -0,0 +1,17 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnknownSlug(t *testing.T) {
+	setupTestSite(t, nil)
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+	w := getRoute(router, "/p/missing/page")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "Page Not Found") {
+		t.Errorf("GET /p/missing/page = %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"encoding/json"
+	"intermark/internal/database"
+	"intermark/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// GetSearch handles /search?q=. Responds with JSON when `format=json` is set or the client accepts it, html otherwise.
+func GetSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		results, err := database.Search(query, limit)
+		if err != nil {
+			blog.Errorf("Error searching for '%s': %v", query, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// json
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{"Query": query, "Results": results}); err != nil {
+				blog.Errorf("Error encoding search results: %v", err)
+			}
+			return
+		}
+		// html
+		data := map[string]interface{}{"Title": utils.Config.Title, "Layout": database.GetPublicLayout(), "Query": query, "Results": results, "Hamburger": false, "Edit": false}
+		if err := Templates.ExecuteTemplate(w, "search.html", data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
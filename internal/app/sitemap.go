@@ -0,0 +1,53 @@
+package app
+
+import (
+	"encoding/xml"
+	"intermark/internal/database"
+	"intermark/internal/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DEFAULT_ROBOTS_TXT = "User-agent: *\nDisallow: /edit\n"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// GetSitemap serves a sitemap of the pages linked from the layout.
+func GetSitemap() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base := siteURL(w, r)
+		urlSet := sitemapURLSet{URLs: []sitemapURL{}}
+		for _, entry := range database.GetSitemap() {
+			url := sitemapURL{Loc: base + entry.Path}
+			if !entry.LastMod.IsZero() {
+				url.LastMod = entry.LastMod.UTC().Format(time.RFC3339)
+			}
+			urlSet.URLs = append(urlSet.URLs, url)
+		}
+		writeXML(w, "application/xml", urlSet)
+	}
+}
+
+// GetRobots serves robots.txt from the config, with the sitemap location appended.
+func GetRobots() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		robots := utils.Config.RobotsTxt
+		if robots == "" {
+			robots = DEFAULT_ROBOTS_TXT
+		}
+		if !strings.HasSuffix(robots, "\n") {
+			robots += "\n"
+		}
+		base := siteURL(w, r)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(robots + "\nSitemap: " + base + "/sitemap.xml\n"))
+	}
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"encoding/xml"
+	"intermark/internal/database"
+	"intermark/internal/utils"
+	"testing"
+)
+
+func TestSitemapAndRobots(t *testing.T) {
+	setupTestSite(t, map[string]string{"home.md": "<!-- ID: home -->\n# Home\n", "setup.md": "<!-- ID: setup -->\n# Setup\n"})
+	layout := database.GetLayout()
+	layout.Landing = database.ContentMeta{ID: "home", RelPath: "home.md"}
+	layout.Sidebar = []database.SidebarItem{{Name: "Setup", Type: "file", Meta: database.ContentMeta{ID: "setup", RelPath: "setup.md"}}}
+	if err := database.SetLayout(&layout, "test"); err != nil {
+		t.Fatal(err)
+	}
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+
+	var urlSet sitemapURLSet
+	if err := xml.Unmarshal(getRoute(router, "/sitemap.xml").Body.Bytes(), &urlSet); err != nil {
+		t.Fatal(err)
+	}
+	if len(urlSet.URLs) != 2 || urlSet.URLs[0].Loc != "http://example.com/" || urlSet.URLs[1].Loc != "http://example.com/p/setup" || urlSet.URLs[1].LastMod == "" {
+		t.Errorf("sitemap = %+v", urlSet.URLs)
+	}
+
+	tests := []struct {
+		robots, want string
+	}{
+		{"", DEFAULT_ROBOTS_TXT + "\nSitemap: http://example.com/sitemap.xml\n"},
+		{"User-agent: *\nDisallow: /", "User-agent: *\nDisallow: /\n\nSitemap: http://example.com/sitemap.xml\n"},
+	}
+	for _, test := range tests {
+		utils.Config.RobotsTxt = test.robots
+		if got := getRoute(router, "/robots.txt").Body.String(); got != test.want {
+			t.Errorf("robots.txt = %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"intermark/internal/database"
+	"intermark/internal/utils"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-chi/chi/v5"
+)
+
+// push webhook sources
+const (
+	FORGE_GITHUB = "github"
+	FORGE_GITEA  = "gitea"
+	FORGE_GITLAB = "gitlab"
+)
+
+const MAX_UPDATE_BODY = 25 << 20 // largest push payload GitHub sends
+
+// PostUpdate queues a content update and responds with the job. It accepts either the update token as the body,
+// or a push webhook from GitHub, Gitea, or GitLab signed with the update secret. Other events and pushes to other branches are ignored.
+func PostUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_UPDATE_BODY))
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		forge, event := webhookEvent(r.Header)
+		if forge == "" {
+			if string(body) != utils.Config.UpdateToken {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			queueUpdate(w)
+			return
+		}
+		if !validWebhookSignature(forge, r.Header, body) {
+			blog.Warnf("Rejected %s webhook with an invalid signature", forge)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if event != "push" && event != "Push Hook" {
+			blog.Debugf("Ignored %s '%s' event", forge, event)
+			w.Write([]byte("Ignored event: " + event))
+			return
+		}
+		var payload struct {
+			Ref string `json:"ref"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "Invalid push payload", http.StatusBadRequest)
+			return
+		}
+		if !pushesContentBranch(payload.Ref) {
+			blog.Debugf("Ignored %s push to '%s'", forge, payload.Ref)
+			w.Write([]byte("Ignored push to " + payload.Ref))
+			return
+		}
+		queueUpdate(w)
+	}
+}
+
+// pushesContentBranch returns true if ref is the branch of any content source.
+func pushesContentBranch(ref string) bool {
+	for _, source := range utils.Config.ContentSources() {
+		if ref == "refs/heads/"+source.Branch {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEvent returns the forge that sent the request and the event name, forge is empty if it isn't a webhook.
+func webhookEvent(header http.Header) (forge, event string) {
+	// Gitea also sets the GitHub headers, so it's checked first
+	if event := header.Get("X-Gitea-Event"); event != "" {
+		return FORGE_GITEA, event
+	}
+	if event := header.Get("X-Gitlab-Event"); event != "" {
+		return FORGE_GITLAB, event
+	}
+	if event := header.Get("X-GitHub-Event"); event != "" {
+		return FORGE_GITHUB, event
+	}
+	return "", ""
+}
+
+// validWebhookSignature checks the request was sent with the update secret.
+// GitHub and Gitea sign the body with HMAC-SHA256, GitLab sends the secret as a token.
+func validWebhookSignature(forge string, header http.Header, body []byte) bool {
+	secret := utils.Config.UpdateSecret
+	if secret == "" {
+		blog.Warnf("Received a %s webhook but update_secret isn't set", forge)
+		return false
+	}
+	switch forge {
+	case FORGE_GITLAB:
+		return subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+	case FORGE_GITEA:
+		if signature := header.Get("X-Gitea-Signature"); signature != "" {
+			return validHMAC(secret, body, signature)
+		}
+	}
+	signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	return ok && validHMAC(secret, body, signature)
+}
+
+// validHMAC returns true if signature is the hex encoded HMAC-SHA256 of the body.
+func validHMAC(secret string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// GetUpdateStatus returns the job with the given id as JSON.
+func GetUpdateStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := database.GetUpdateJob(chi.URLParam(r, "job"))
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+// PostEditSync returns the commit the site is on, what it's pinned to, and the recently synced commits as JSON.
+func PostEditSync() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		history, err := database.GetSyncHistory()
+		if err != nil {
+			blog.Errorf("Error getting sync history: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		commit := ""
+		if len(history) != 0 {
+			commit = history[0].Commit
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Commit":  commit,
+			"Pin":     database.GetPin(),
+			"Branch":  utils.Config.ContentRepo.Branch,
+			"History": history,
+		})
+	}
+}
+
+// PostEditPin pins the site to a commit hash or tag, or back to the branch if it's empty, and queues an update to
+// deploy it. Rolling back is pinning to a previously synced commit.
+func PostEditPin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pinReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ref := strings.TrimSpace(req.Data.Ref)
+		if err := database.SetPin(ref, currentUser(r).Username); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		queueUpdate(w)
+	}
+}
+
+// queueUpdate queues a content update and writes the job with a 202 status.
+func queueUpdate(w http.ResponseWriter) {
+	job, err := database.QueueUpdate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/update/status/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
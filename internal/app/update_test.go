@@ -0,0 +1,204 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"intermark/internal/database"
+	"intermark/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postUpdate sends body to /update with the given headers.
+func postUpdate(handler http.Handler, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(body))
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestUpdateJobs(t *testing.T) {
+	setupTestSite(t, map[string]string{"home.md": "<!-- ID: home -->\n# Home\n"})
+	utils.Config.UpdateToken = "token"
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+
+	if w := postUpdate(router, "wrong", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token = %d", w.Code)
+	}
+	w := postUpdate(router, "token", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("update = %d: %s", w.Code, w.Body.String())
+	}
+	var job database.UpdateJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Location") != "/update/status/"+job.ID {
+		t.Errorf("Location = %q", w.Header().Get("Location"))
+	}
+
+	// poll the status until the worker finishes the job
+	for deadline := time.Now().Add(10 * time.Second); job.Status != database.JOB_DONE; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) || job.Status == database.JOB_FAILED {
+			t.Fatalf("job = %+v", job)
+		}
+		w = getRoute(router, "/update/status/"+job.ID)
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("status = %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.Stage != database.STAGE_CLEANUP || job.Started.IsZero() || job.Finished.Before(job.Started) {
+		t.Errorf("job = %+v", job)
+	}
+	if w := getRoute(router, "/update/status/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job = %d", w.Code)
+	}
+}
+
+// sign returns the hex encoded HMAC-SHA256 of body.
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidWebhookSignature(t *testing.T) {
+	config := utils.Config
+	t.Cleanup(func() { utils.Config = config })
+	body := `{"ref":"refs/heads/main"}`
+	tests := []struct {
+		name   string
+		secret string
+		forge  string
+		header map[string]string
+		want   bool
+	}{
+		{"github valid", "s3cret", FORGE_GITHUB, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("s3cret", body)}, true},
+		{"github wrong secret", "s3cret", FORGE_GITHUB, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("other", body)}, false},
+		{"github no prefix", "s3cret", FORGE_GITHUB, map[string]string{"X-Hub-Signature-256": sign("s3cret", body)}, false},
+		{"github not hex", "s3cret", FORGE_GITHUB, map[string]string{"X-Hub-Signature-256": "sha256=zz"}, false},
+		{"github sha1 only", "s3cret", FORGE_GITHUB, map[string]string{"X-Hub-Signature": "sha1=abc"}, false},
+		{"github missing", "s3cret", FORGE_GITHUB, nil, false},
+		{"gitea valid", "s3cret", FORGE_GITEA, map[string]string{"X-Gitea-Signature": sign("s3cret", body)}, true},
+		{"gitea invalid", "s3cret", FORGE_GITEA, map[string]string{"X-Gitea-Signature": sign("other", body)}, false},
+		{"gitea invalid doesn't fall back", "s3cret", FORGE_GITEA, map[string]string{"X-Gitea-Signature": sign("other", body), "X-Hub-Signature-256": "sha256=" + sign("s3cret", body)}, false},
+		{"gitea falls back to github header", "s3cret", FORGE_GITEA, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("s3cret", body)}, true},
+		{"gitea fallback invalid", "s3cret", FORGE_GITEA, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("other", body)}, false},
+		{"gitlab token", "s3cret", FORGE_GITLAB, map[string]string{"X-Gitlab-Token": "s3cret"}, true},
+		{"gitlab wrong token", "s3cret", FORGE_GITLAB, map[string]string{"X-Gitlab-Token": "s3cre"}, false},
+		{"gitlab ignores signatures", "s3cret", FORGE_GITLAB, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("s3cret", body)}, false},
+		{"empty secret", "", FORGE_GITHUB, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("", body)}, false},
+		{"empty secret gitlab", "", FORGE_GITLAB, map[string]string{"X-Gitlab-Token": ""}, false},
+		{"empty secret gitea", "", FORGE_GITEA, map[string]string{"X-Gitea-Signature": sign("", body)}, false},
+	}
+	for _, test := range tests {
+		utils.Config.UpdateSecret = test.secret
+		header := http.Header{}
+		for key, value := range test.header {
+			header.Set(key, value)
+		}
+		if got := validWebhookSignature(test.forge, header, []byte(body)); got != test.want {
+			t.Errorf("%s: validWebhookSignature() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidHMAC(t *testing.T) {
+	tests := []struct {
+		signature string
+		want      bool
+	}{
+		{sign("key", "body"), true},
+		{strings.ToUpper(sign("key", "body")), true},
+		{sign("key", "other"), false},
+		{sign("key", "body")[:10], false},
+		{"", false},
+		{"not hex", false},
+	}
+	for _, test := range tests {
+		if got := validHMAC("key", []byte("body"), test.signature); got != test.want {
+			t.Errorf("validHMAC(%q) = %v, want %v", test.signature, got, test.want)
+		}
+	}
+}
+
+func TestPushWebhooks(t *testing.T) {
+	setupTestSite(t, map[string]string{"home.md": "<!-- ID: home -->\n# Home\n"})
+	utils.Config.UpdateSecret = "s3cret"
+	blog := t.TempDir()
+	runGit(t, blog, "init", "-q", "-b", "posts")
+	commitTestContent(t, blog, map[string]string{"post.md": "<!-- ID: post -->\n# Post\n"})
+	utils.Config.Sources = []utils.ContentSource{{Name: "blog", URL: blog, Branch: "posts", Mount: "blog"}}
+	usingTLS := false
+	router := NewRouter(&usingTLS)
+
+	push := func(ref string) string { return `{"ref":"` + ref + `"}` }
+	github := func(event, body string) map[string]string {
+		return map[string]string{"X-GitHub-Event": event, "X-Hub-Signature-256": "sha256=" + sign("s3cret", body)}
+	}
+	tests := []struct {
+		name   string
+		body   string
+		header map[string]string
+		want   int
+	}{
+		{"github push", push("refs/heads/main"), github("push", push("refs/heads/main")), http.StatusAccepted},
+		{"push to a source branch", push("refs/heads/posts"), github("push", push("refs/heads/posts")), http.StatusAccepted},
+		{"push to another branch", push("refs/heads/dev"), github("push", push("refs/heads/dev")), http.StatusOK},
+		{"tag push", push("refs/tags/main"), github("push", push("refs/tags/main")), http.StatusOK},
+		{"other event", push("refs/heads/main"), github("issues", push("refs/heads/main")), http.StatusOK},
+		{"bad signature", push("refs/heads/main"), github("push", push("refs/heads/dev")), http.StatusUnauthorized},
+		{"bad payload", "not json", github("push", "not json"), http.StatusBadRequest},
+		{"gitlab push", push("refs/heads/main"), map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "s3cret"}, http.StatusAccepted},
+		{"gitlab other branch", push("refs/heads/dev"), map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "s3cret"}, http.StatusOK},
+		{"gitea push", push("refs/heads/main"), map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Gitea-Signature": sign("s3cret", push("refs/heads/main"))}, http.StatusAccepted},
+	}
+	for _, test := range tests {
+		w := postUpdate(router, test.body, test.header)
+		if w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, w.Code, test.want, w.Body.String())
+		}
+		if w.Code == http.StatusOK && !strings.HasPrefix(w.Body.String(), "Ignored") {
+			t.Errorf("%s: body = %q", test.name, w.Body.String())
+		}
+	}
+
+	// webhooks are rejected while update_secret is empty
+	utils.Config.UpdateSecret = ""
+	if w := postUpdate(router, push("refs/heads/main"), map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign("", push("refs/heads/main"))}); w.Code != http.StatusUnauthorized {
+		t.Errorf("empty secret: status = %d", w.Code)
+	}
+}
+
+func TestWebhookEvent(t *testing.T) {
+	tests := []struct {
+		header       map[string]string
+		forge, event string
+	}{
+		{map[string]string{"X-GitHub-Event": "push"}, FORGE_GITHUB, "push"},
+		{map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push"}, FORGE_GITEA, "push"},
+		{map[string]string{"X-Gitlab-Event": "Push Hook"}, FORGE_GITLAB, "Push Hook"},
+		{nil, "", ""},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for key, value := range test.header {
+			header.Set(key, value)
+		}
+		if forge, event := webhookEvent(header); forge != test.forge || event != test.event {
+			t.Errorf("webhookEvent(%v) = %s, %s", test.header, forge, event)
+		}
+	}
+}
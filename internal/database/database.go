@@ -2,7 +2,6 @@ package database
 
 import (
 	_ "embed"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"intermark/internal/files"
@@ -10,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,14 +23,23 @@ import (
 
 // ==== Variables =============================================================
 
-const MISSING_FILE = "MISSING_FILE"
+const (
+	MISSING_FILE  = "MISSING_FILE"
+	SYSTEM_AUTHOR = "system" // author of layout changes not made by a user
+)
 
 var (
 	DB *gorm.DB = nil
 	// paths
 	DB_PATH           = filepath.Join("data", "data.db")
 	CONTENT_REPO_PATH = filepath.Join("data", "content")
+	SOURCES_PATH      = filepath.Join("data", "sources") // clones of the additional content sources, by name
 	CONTENT_HTML_PATH = filepath.Join("data", "html")
+	ASSETS_PATH       = filepath.Join("data", "assets")
+	CSS_PATH          = filepath.Join("data", "css", "out.css")
+	// updates are built here, then swapped in once the database changes are committed
+	STAGED_ASSETS_PATH = filepath.Join("data", "assets.staged")
+	STAGED_CSS_PATH    = filepath.Join("data", "css", "out.staged.css")
 	// Value type is Layout
 	layoutCache   = atomic.Value{}
 	UpdateMutex   = sync.Mutex{}
@@ -75,21 +84,31 @@ type ContentMeta struct {
 
 // LayoutModel. Aside from the ID, this is just a marshalled JSON of the Layout struct.
 type LayoutModel struct {
-	ID      uint `gorm:"primaryKey"`
-	Sidebar string
-	Footer  string
-	Landing string
-	Missing string
+	ID        uint `gorm:"primaryKey"`
+	Sidebar   string
+	Footer    string
+	Landing   string
+	Missing   string
+	Author    string // username of whoever saved it, or "system"
+	UpdatedAt time.Time
 }
 
 type ContentModel struct {
 	ContentMeta
-	HTML string
-	MD   string
+	HTML      string
+	MD        string
+	Source    string            `gorm:"index"` // name of the content source, empty for the main repository
+	Updated   time.Time         `gorm:"index"` // commit time of the last change to the page
+	Front     utils.FrontMatter `gorm:"embedded;embeddedPrefix:front_"`
+	Published bool              `gorm:"index"` // false for drafts and pages scheduled for later
+	FrontErr  string            // why the front matter couldn't be parsed, empty if it's valid
+	TOC       []utils.TOCEntry  `gorm:"serializer:json"`
+	Links     []string          `gorm:"serializer:json"` // ids of the pages linked to with id links
 }
 
 type AssetModel struct {
-	ID     string `json:"ID" gorm:"primaryKey"` // rel path
+	ID     string `json:"ID" gorm:"primaryKey"` // path in the assets directory, the rel path prefixed by the mount of its source
+	Source string `json:"Source" gorm:"index"`
 	Commit string `json:"Commit"`
 }
 
@@ -103,8 +122,8 @@ func Init() {
 		return
 	}
 
-	// open the database
-	db, err := gorm.Open(sqlite.Open(DB_PATH), &gorm.Config{
+	// open the database, WAL lets pages be read while an update is being written
+	db, err := gorm.Open(sqlite.Open(DB_PATH+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(15000)"), &gorm.Config{
 		Logger:      logger.Default.LogMode(logger.Silent), // logger.Silent or logger.Info
 		PrepareStmt: true,
 	})
@@ -112,16 +131,54 @@ func Init() {
 		blog.Fatalf(1, time.Second*3, "failed to connect database: %v", err)
 	}
 
-	// migrate the schemas
-	if err = db.AutoMigrate(&LayoutModel{}, &ContentModel{}, &AssetModel{}); err != nil {
+	// migrate the schemas, noting if columns derived from the markdown are new so existing pages can be re-rendered
+	rerender := db.Migrator().HasTable(&ContentModel{}) && !(db.Migrator().HasColumn(&ContentModel{}, "front_slug") &&
+		db.Migrator().HasColumn(&ContentModel{}, "front_publish") && db.Migrator().HasColumn(&ContentModel{}, "front_err") &&
+		db.Migrator().HasColumn(&ContentModel{}, "published") &&
+		db.Migrator().HasColumn(&ContentModel{}, "toc") && db.Migrator().HasColumn(&ContentModel{}, "links"))
+	if err = db.AutoMigrate(&LayoutModel{}, &LayoutRevisionModel{}, &ContentModel{}, &AssetModel{}, &SlugModel{}, &UserModel{}, &SessionModel{}, &UpdateReportModel{}, &SyncModel{}, &PinModel{}); err != nil {
 		blog.Fatalf(1, time.Second*3, "failed to migrate database: %v", err)
 	}
 
 	// set the global database variable
 	DB = db
 
+	// create / fill the search index
+	if err = initSearch(); err != nil {
+		blog.Fatalf(1, time.Second*3, "failed to initialize search index: %v", err)
+	}
+
+	if rerender {
+		if err = rerenderContent(); err != nil {
+			blog.Fatalf(1, time.Second*3, "failed to re-render content: %v", err)
+		}
+	}
+
+	// deploy the pinned commit or tag, if any
+	if err = initPin(); err != nil {
+		blog.Fatalf(1, time.Second*3, "failed to load pin: %v", err)
+	}
+	if err := utils.Config.CheckSources(); err != nil {
+		blog.Fatalf(1, time.Second*3, "invalid sources: %v", err)
+	}
+
+	// cache which pages are drafts or scheduled
+	if err = loadUnpublishedCache(); err != nil {
+		blog.Fatalf(1, time.Second*3, "failed to load unpublished pages: %v", err)
+	}
+
+	// generate / cache the page slugs
+	if err = initSlugs(); err != nil {
+		blog.Fatalf(1, time.Second*3, "failed to initialize slugs: %v", err)
+	}
+
+	// create the first admin if needed
+	if err = initUsers(); err != nil {
+		blog.Fatalf(1, time.Second*3, "failed to initialize users: %v", err)
+	}
+
 	// calculate the default sandbox html
-	sandBoxHTML, err = utils.MdToHTML(sandboxMD)
+	sandBoxHTML, _, err = utils.MdToHTML(sandboxMD, pageResolver(DB, nil))
 	if err != nil {
 		blog.Fatalf(1, time.Second*3, "failed to convert sandbox markdown to html: %v", err)
 	}
@@ -147,12 +204,18 @@ func Init() {
 				},
 				Landing: ContentMeta{},
 			}
-			if err := SetLayout(&defaultLayout); err != nil {
+			if err := SetLayout(&defaultLayout, SYSTEM_AUTHOR); err != nil {
 				blog.Fatalf(1, time.Second*3, "failed to initialize layout: %v", err)
 			}
 		}
 	} else {
 		layoutCache.Store(*layout)
+		if err := initLayoutRevisions(); err != nil {
+			blog.Fatalf(1, time.Second*3, "failed to initialize layout revisions: %v", err)
+		}
+		if err := refreshSitemap(); err != nil {
+			blog.Fatalf(1, time.Second*3, "failed to build sitemap: %v", err)
+		}
 	}
 }
 
@@ -180,95 +243,118 @@ func GetLayout() Layout {
 
 // GetLayoutDB retrieves the Layout from the db. For frequent access, use LayoutCache.Load()
 func GetLayoutDB() (*Layout, error) {
-	var result Layout
 	var layout LayoutModel
 	if err := DB.First(&layout).Error; err != nil {
 		return nil, err
 	}
-	if err := json.Unmarshal([]byte(layout.Sidebar), &result.Sidebar); err != nil {
-		return nil, err
-	}
-	if err := json.Unmarshal([]byte(layout.Footer), &result.Footer); err != nil {
-		return nil, err
-	}
-	if err := json.Unmarshal([]byte(layout.Landing), &result.Landing); err != nil {
-		return nil, err
-	}
-	return &result, nil
+	return unmarshalLayout(layout.Sidebar, layout.Footer, layout.Landing)
 }
 
-// SetLayout sets the Layout in the db and updates the cache.
-func SetLayout(layout *Layout) error {
-	blog.Debugf("Setting layout: %v", layout)
-	// marshal the layout
-	mS, err := json.Marshal(layout.Sidebar)
-	if err != nil {
+// SetLayout sets the Layout in the db, records it as a new revision if it changed, and updates the cache.
+// Author is the username of whoever made the change.
+func SetLayout(layout *Layout, author string) error {
+	// save the layout and revision together, then cache it
+	if err := DB.Transaction(func(tx *gorm.DB) error { return saveLayout(tx, layout, author) }); err != nil {
 		return err
 	}
-	blog.Debugf("Marshalled Sidebar: %s", mS)
-	mF, err := json.Marshal(layout.Footer)
-	if err != nil {
-		return err
+	layoutCache.Store(*layout)
+	if err := refreshSitemap(); err != nil {
+		blog.Errorf("Error refreshing sitemap: %v", err)
 	}
-	blog.Debugf("Marshalled Footer: %s", mF)
-	mL, err := json.Marshal(layout.Landing)
+	return nil
+}
+
+// saveLayout saves the layout and records it as a new revision if it changed, without updating the cache.
+func saveLayout(tx *gorm.DB, layout *Layout, author string) error {
+	blog.Debugf("Setting layout: %v", layout)
+	mS, mF, mL, err := marshalLayout(layout)
 	if err != nil {
 		return err
 	}
-	blog.Debugf("Marshalled Landing: %s", mL)
-	// save the layout and cache it
-	if err := DB.Save(&LayoutModel{ID: 1, Sidebar: string(mS), Footer: string(mF), Landing: string(mL)}).Error; err != nil {
+	if err := tx.Save(&LayoutModel{ID: 1, Sidebar: mS, Footer: mF, Landing: mL, Author: author}).Error; err != nil {
 		return err
 	}
-	layoutCache.Store(*layout)
-	return nil
+	return saveLayoutRevision(tx, layout, author)
 }
 
-// GetHTML retrieves the HTML content with the given id.
-func GetHTML(id string) (string, error) {
+// GetContent retrieves the content with the given id, without the markdown.
+// If it doesn't exist, NotFoundContent is returned.
+func GetContent(id string) (*ContentModel, error) {
 	var content ContentModel
-	if err := DB.Select("html").Where("id = ?", id).First(&content).Error; err != nil {
-		return `<h2>Oops... Page Not Found!</h2>`, utils.Ternary(errors.Is(err, gorm.ErrRecordNotFound), nil, err)
+	if err := DB.Omit("md").Where("id = ?", id).First(&content).Error; err != nil {
+		return NotFoundContent(), utils.Ternary(errors.Is(err, gorm.ErrRecordNotFound), nil, err)
 	}
-	return content.HTML, nil
+	return &content, nil
 }
 
-// Update updates the content for the site. Returned errors are generic and safe to display.
-func Update() error {
-	if utils.Config.ContentRepo.URL == "" {
-		blog.Error("ContentRepo URL is empty")
+// NotFoundContent returns content that only has a not found message as its HTML.
+func NotFoundContent() *ContentModel {
+	return &ContentModel{HTML: `<h2>Oops... Page Not Found!</h2>`}
+}
+
+// runUpdate updates the content for the site and stores a report of the problems found. The job may be nil.
+// Returned errors are generic and safe to display.
+func runUpdate(job *UpdateJob) error {
+	report := &UpdateReport{Started: time.Now()}
+	err := update(report, job)
+	report.Finished = time.Now()
+	if err != nil {
+		report.Error = err.Error()
+	} else {
+		if err := recordSync("", report.Commit, report.Pin); err != nil {
+			blog.Errorf("Error recording synced commit: %v", err)
+		}
+		for source, commit := range report.Sources {
+			if err := recordSync(source, commit, ""); err != nil {
+				blog.Errorf("Error recording synced commit of %s: %v", sourceLabel(source), err)
+			}
+		}
+	}
+	if err := saveUpdateReport(report); err != nil {
+		blog.Errorf("Error saving update report: %v", err)
+	}
+	notifyUpdateReport(report)
+	return err
+}
+
+func update(report *UpdateReport, job *UpdateJob) error {
+	if utils.Config.ContentRepo.URL == "" && utils.Config.ContentRepo.Path == "" {
+		blog.Error("ContentRepo URL and path are empty")
 		return errors.New("content repository URL in config is empty")
 	}
 
 	UpdateMutex.Lock()
 	defer UpdateMutex.Unlock()
 
-	var commit string
-
-	// clone or update the content repository
-	if exists, err := files.Exists(filepath.Join(CONTENT_REPO_PATH, ".git")); err != nil {
-		blog.Errorf("Error checking if a .git directory already exists: %v", err)
-		return errors.New("error checking if a .git directory already exists")
-	} else if !exists {
-		if commit, err = utils.GitClone(utils.Config.ContentRepo.URL, CONTENT_REPO_PATH); err != nil {
-			blog.Errorf("Error cloning the content repository: %v", err)
-			return errors.New("error cloning the content repository")
+	// clone or update every content source, the main repository checks out the pinned commit if there is one
+	job.setStage(STAGE_FETCH)
+	pin := GetPin()
+	var synced []*syncedSource
+	for _, source := range utils.Config.ContentSources() {
+		src, err := syncSource(source, utils.Ternary(source.Name == "", pin, ""))
+		if err != nil {
+			return err
 		}
-		blog.Debugf(`Cloned: '%s', commit: '%s'`, utils.Config.ContentRepo, commit)
-	} else {
-		if commit, err = utils.GitReset(CONTENT_REPO_PATH); err != nil {
-			blog.Errorf("Error resetting the content repository: %v", err)
-			return errors.New("error resetting the content repository")
+		synced = append(synced, src)
+		if source.Name != "" {
+			if report.Sources == nil {
+				report.Sources = make(map[string]string)
+			}
+			report.Sources[source.Name] = src.commit
 		}
-		blog.Debugf(`Reset: '%s', commit: '%s'`, utils.Config.ContentRepo, commit)
 	}
+	report.Commit, report.Pin = synced[0].commit, pin
 
 	// load the new meta data for all pages
-	var err error
+	job.setStage(STAGE_IDS)
 	var newMetaDatas []ContentMeta
-	if newMetaDatas, err = loadIDs(CONTENT_REPO_PATH); err != nil {
-		blog.Errorf("Error loading ids: %v", err)
-		return errors.New("error loading ids")
+	for _, src := range synced {
+		metaDatas, err := loadIDs(src.path, src.Name, report)
+		if err != nil {
+			blog.Errorf("Error loading ids of %s: %v", sourceLabel(src.Name), err)
+			return errors.New("error loading ids of " + sourceLabel(src.Name))
+		}
+		newMetaDatas = append(newMetaDatas, metaDatas...)
 	}
 	if len(newMetaDatas) == 0 {
 		blog.Warnf("No ids found, skipping update")
@@ -276,52 +362,234 @@ func Update() error {
 	}
 
 	// get the old meta data, copy the commits from old to new, and clean the html directory
-	var oldMetaDatas []ContentMeta
-	if oldMetaDatas, err = GetMeta(); err != nil {
+	oldMetaDatas, err := GetMeta()
+	if err != nil {
 		blog.Errorf("Error getting current meta data: %v", err)
 		return errors.New("error getting current content meta data from the database")
 	}
 	copyCommits(oldMetaDatas, newMetaDatas)
 
-	// update the content
+	// render the pages and stage the assets and CSS first, so the transaction only writes the results
+	metaDataMap := make(map[string]ContentMeta, len(newMetaDatas))
 	for _, metaData := range newMetaDatas {
-		if err = updateContent(CONTENT_REPO_PATH, commit, metaData); err != nil {
-			blog.Errorf("Error updating content: %v", err)
-			return errors.New("error updating content: '" + metaData.ID + "', See server logs for more information")
+		metaDataMap[metaData.ID] = metaData
+	}
+	staged, err := stageUpdate(report, job, synced, newMetaDatas, oldMetaDatas, metaDataMap)
+	if err != nil {
+		discardStaged()
+		return err
+	}
+
+	// write everything in one transaction so readers only see the site before or after this commit
+	if err := DB.Transaction(func(tx *gorm.DB) error { return saveUpdate(tx, report, job, staged, newMetaDatas) }); err != nil {
+		discardStaged()
+		return err
+	}
+
+	// swap in the staged files and caches
+	if err := swapStaged(); err != nil {
+		blog.Errorf("Error swapping in staged assets and CSS: %v", err)
+		return errors.New("error swapping in staged assets and CSS")
+	}
+	layoutCache.Store(staged.layout)
+	if err := loadSlugCache(); err != nil {
+		blog.Errorf("Error loading slugs: %v", err)
+		return errors.New("error loading slugs")
+	}
+	// refresh the unpublished pages and sitemap now that slugs and updated times are final
+	if err := refreshPublished(); err != nil {
+		blog.Errorf("Error refreshing published pages: %v", err)
+		return errors.New("error refreshing published pages")
+	}
+
+	return nil
+}
+
+// stagedUpdate is an update prepared by stageUpdate, for saveUpdate to write to the database.
+type stagedUpdate struct {
+	metaDataMap map[string]ContentMeta   // every page of the update, by id
+	contents    map[string]*ContentModel // rendered pages to save, by id: changed ones and ones linking to pages that changed
+	slugs       map[string]string        // current slug of every page, by id
+	movedIDs    []string                 // pages whose slug changed
+	layout      Layout                   // cached once the update is saved
+	assets      []AssetModel             // every asset, the files are in STAGED_ASSETS_PATH
+}
+
+// stageUpdate renders the pages of an update and stages its assets and CSS files without writing to the database.
+func stageUpdate(report *UpdateReport, job *UpdateJob, synced []*syncedSource, newMetaDatas, oldMetaDatas []ContentMeta, metaDataMap map[string]ContentMeta) (*stagedUpdate, error) {
+	staged := &stagedUpdate{metaDataMap: metaDataMap, contents: make(map[string]*ContentModel)}
+
+	// load the pages that changed
+	job.setStage(STAGE_CONTENT)
+	sources := make(map[string]*syncedSource, len(synced))
+	for _, src := range synced {
+		sources[src.Name] = src
+	}
+	for _, metaData := range newMetaDatas {
+		content, err := loadContent(sources[sourceOf(metaData.ID)], metaData)
+		if err != nil {
+			blog.Errorf("Error loading content: %v", err)
+			return nil, errors.New("error loading content: '" + metaData.ID + "', See server logs for more information")
+		} else if content != nil {
+			staged.contents[metaData.ID] = content
 		}
 	}
 
-	// update the layout with new meta data
-	layout := layoutCache.Load().(Layout)
-	metaDataMap := make(map[string]ContentMeta, len(newMetaDatas))
+	// work out the slugs before rendering so links to pages resolve to their new urls, old ones are kept as redirects
+	overrides, err := slugOverrides(DB, staged.contents)
+	if err != nil {
+		blog.Errorf("Error reading slugs from front matter: %v", err)
+		return nil, errors.New("error reading slugs from front matter")
+	}
+	if staged.slugs, staged.movedIDs, err = planSlugs(DB, newMetaDatas, overrides); err != nil {
+		blog.Errorf("Error updating slugs: %v", err)
+		return nil, errors.New("error updating slugs")
+	}
+
+	// render once every changed page is loaded, so links show the new titles
+	resolve := stagedResolver(DB, metaDataMap, staged.contents, staged.slugs)
+	changedIDs := make(map[string]bool)
+	for id, content := range staged.contents {
+		if err := renderContent(content, resolve); err != nil {
+			blog.Errorf("Error rendering content: %v", err)
+			return nil, errors.New("error rendering content: '" + id + "', See server logs for more information")
+		}
+		changedIDs[id] = true
+		blog.Debugf("%s updated", id)
+	}
+
+	// render pages linking to pages that changed, moved, or were removed again, so titles and urls are current
 	for _, metaData := range newMetaDatas {
-		metaDataMap[metaData.ID] = metaData
+		if metaData.RelPath == MISSING_FILE {
+			changedIDs[metaData.ID] = true
+		}
+	}
+	for _, id := range staged.movedIDs {
+		changedIDs[id] = true
+	}
+	for _, metaData := range oldMetaDatas {
+		if _, ok := metaDataMap[metaData.ID]; !ok {
+			changedIDs[metaData.ID] = true
+		}
+	}
+	linking, err := linkingIDs(DB, changedIDs)
+	if err != nil {
+		blog.Errorf("Error finding linking pages: %v", err)
+		return nil, errors.New("error re-rendering linking pages")
+	}
+	for _, id := range linking {
+		if _, ok := metaDataMap[id]; !ok || staged.contents[id] != nil {
+			continue
+		}
+		var content ContentModel
+		if err := DB.Where("id = ?", id).First(&content).Error; err != nil {
+			blog.Errorf("Error loading linking page: %v", err)
+			return nil, errors.New("error re-rendering linking pages")
+		}
+		if err := renderContent(&content, resolve); err != nil {
+			blog.Errorf("Error re-rendering linking page: %v", err)
+			return nil, errors.New("error re-rendering linking pages")
+		}
+		staged.contents[id] = &content
+		blog.Debugf("%s re-rendered, linked page changed", id)
 	}
-	updateSidebarItems(layout.Sidebar, metaDataMap)
-	updateFooterItems(layout.Footer, metaDataMap)
-	SetLayout(&layout)
 
-	// update the assets
-	if err := updateAssets(commit); err != nil {
+	// update a copy of the layout with the new meta data, the cached one is only replaced once the update is saved
+	current := layoutCache.Load().(Layout)
+	layout, err := cloneLayout(&current)
+	if err != nil {
+		blog.Errorf("Error copying layout: %v", err)
+		return nil, errors.New("error copying layout")
+	}
+	updateSidebarItems(layout.Sidebar, metaDataMap, "Sidebar", report)
+	updateFooterItems(layout.Footer, metaDataMap, report)
+	if meta, ok := metaDataMap[layout.Landing.ID]; ok {
+		layout.Landing = meta
+	} else if layout.Landing.ID != "" {
+		blog.Errorf("ID: '%s', not found for the landing page", layout.Landing.ID)
+		report.addOrphanedItem("Landing", layout.Landing.ID)
+	}
+	staged.layout = layout
+
+	// stage the assets
+	job.setStage(STAGE_ASSETS)
+	if staged.assets, err = stageAssets(DB, synced); err != nil {
 		blog.Errorf("Error updating assets: %v", err)
-		return errors.New("error updating assets")
+		return nil, errors.New("error updating assets")
 	}
 
 	// run tailwind
-	if err = RunTailwind(false); err != nil {
+	job.setStage(STAGE_TAILWIND)
+	if err = runTailwind(DB, staged, STAGED_CSS_PATH, false); err != nil {
 		blog.Errorf("Error running tailwindcss: %v", err)
-		return errors.New("error running tailwindcss")
+		return nil, errors.New("error running tailwindcss")
+	}
+
+	return staged, nil
+}
+
+// saveUpdate writes a staged update using tx: the pages and their slugs and search entries, the layout, and the assets.
+// Pages no longer in newMetaDatas are removed, then every page is checked for problems to report.
+func saveUpdate(tx *gorm.DB, report *UpdateReport, job *UpdateJob, staged *stagedUpdate, newMetaDatas []ContentMeta) error {
+	job.setStage(STAGE_SAVE)
+	if err := applySlugs(tx, staged.slugs, staged.movedIDs); err != nil {
+		blog.Errorf("Error updating slugs: %v", err)
+		return errors.New("error updating slugs")
+	}
+	for id, content := range staged.contents {
+		if err := tx.Save(content).Error; err != nil {
+			blog.Errorf("Error saving content: %v", err)
+			return errors.New("error saving content: '" + id + "', See server logs for more information")
+		}
+		if err := indexContent(tx, content); err != nil {
+			blog.Errorf("Error indexing content: %v", err)
+			return errors.New("error indexing content: '" + id + "', See server logs for more information")
+		}
+	}
+	if err := saveLayout(tx, &staged.layout, SYSTEM_AUTHOR); err != nil {
+		blog.Errorf("Error saving layout: %v", err)
+		return errors.New("error saving layout")
+	}
+	if err := saveAssets(tx, staged.assets); err != nil {
+		blog.Errorf("Error saving assets: %v", err)
+		return errors.New("error updating assets")
 	}
 
 	// cleanup the content
-	if err := cleanupContent(newMetaDatas); err != nil {
+	job.setStage(STAGE_CLEANUP)
+	if err := cleanupContent(tx, newMetaDatas); err != nil {
 		blog.Errorf("Error cleaning up content: %v", err)
 		return errors.New("error cleaning up content")
 	}
 
+	// check every page for links to pages or assets that don't exist
+	if err := checkContentLinks(tx, report, staged.metaDataMap, STAGED_ASSETS_PATH); err != nil {
+		blog.Errorf("Error checking content links: %v", err)
+		return errors.New("error checking content links")
+	}
+
+	// every page with invalid front matter, not just the changed ones, so they're reported until fixed
+	if err := checkFrontMatter(tx, report, staged.metaDataMap); err != nil {
+		blog.Errorf("Error checking front matter: %v", err)
+		return errors.New("error checking front matter")
+	}
+
+	// fill in updated times for pages last changed before they were tracked
+	if err := backfillUpdated(tx); err != nil {
+		blog.Errorf("Error backfilling updated times: %v", err)
+		return errors.New("error backfilling updated times")
+	}
+
 	return nil
 }
 
+// GetSandbox returns the current sandbox markdown and html, shared by all users.
+func GetSandbox() (string, string) {
+	sandboxMutex.Lock()
+	defer sandboxMutex.Unlock()
+	return sandboxMD, sandBoxHTML
+}
+
 func UpdateSandbox(newMD string) (string, error) {
 	sandboxMutex.Lock()
 	defer sandboxMutex.Unlock()
@@ -334,7 +602,7 @@ func UpdateSandbox(newMD string) (string, error) {
 	// update the sandbox markdown and convert to html
 	sandboxMD = newMD
 	var err error
-	sandBoxHTML, err = utils.MdToHTML(sandboxMD)
+	sandBoxHTML, _, err = utils.MdToHTML(sandboxMD, pageResolver(DB, nil))
 	if err != nil {
 		return "", fmt.Errorf("error converting markdown to html: %v", err)
 	}
@@ -358,6 +626,12 @@ func GetMeta() ([]ContentMeta, error) {
 
 // RunTailwind runs the tailwindcss CLI to generate the CSS file.
 func RunTailwind(sandboxOnly bool) error {
+	return runTailwind(DB, nil, CSS_PATH, sandboxOnly)
+}
+
+// runTailwind generates the CSS file at output. Unless sandboxOnly, the html of the content in db is written out for tailwind to scan first.
+// If staged is given, the html of its pages is used instead, and pages it removes are left out.
+func runTailwind(db *gorm.DB, staged *stagedUpdate, output string, sandboxOnly bool) error {
 	tailwindMutex.Lock()
 	defer tailwindMutex.Unlock()
 
@@ -383,9 +657,14 @@ func RunTailwind(sandboxOnly bool) error {
 		}
 		// copy all html content to it
 		var contents []ContentModel
-		result := DB.FindInBatches(&contents, 50, func(tx *gorm.DB, batch int) error {
+		result := db.Select("id", "html").FindInBatches(&contents, 50, func(tx *gorm.DB, batch int) error {
 			var i int64
 			for i = 0; i < tx.RowsAffected; i++ {
+				if staged != nil {
+					if _, ok := staged.metaDataMap[contents[i].ID]; !ok || staged.contents[contents[i].ID] != nil {
+						continue
+					}
+				}
 				if err := files.CreateFile(filepath.Join(dbHtmlPath, fmt.Sprint(contents[i].ID)+".html"), contents[i].HTML); err != nil {
 					return err
 				}
@@ -395,12 +674,18 @@ func RunTailwind(sandboxOnly bool) error {
 		if result.Error != nil {
 			return result.Error
 		}
+		if staged != nil {
+			for id, content := range staged.contents {
+				if err := files.CreateFile(filepath.Join(dbHtmlPath, id+".html"), content.HTML); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	// run the tailwindcss CLI
 	tailInput := filepath.Join("data", "css", "app.css")
-	tailOutput := filepath.Join("data", "css", "out.css")
-	cmd := exec.Command("npx", "@tailwindcss/cli", "-i", tailInput, "-o", tailOutput, "--minify")
+	cmd := exec.Command("npx", "@tailwindcss/cli", "-i", tailInput, "-o", output, "--minify")
 	if utils.DebugMode {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
@@ -410,45 +695,48 @@ func RunTailwind(sandboxOnly bool) error {
 
 // ==== Helper / Private Functions ============================================
 
+// copyCommits copies the commits of pages that haven't moved, moved pages are rendered again.
 func copyCommits(source, target []ContentMeta) {
-	// key: id, value: commit
-	commitMap := make(map[string]string, len(source))
+	// key: id, value: meta data
+	metaMap := make(map[string]ContentMeta, len(source))
 	for _, item := range source {
-		commitMap[item.ID] = item.Commit
+		metaMap[item.ID] = item
 	}
 	// copy the commits
 	for i, item := range target {
-		if commit, exists := commitMap[item.ID]; exists {
-			target[i].Commit = commit
+		if old, exists := metaMap[item.ID]; exists && old.RelPath == item.RelPath {
+			target[i].Commit = old.Commit
 		}
 	}
 }
 
 // updateSidebarItems Recursively updates the meta data for all files in the sidebar.
-func updateSidebarItems(items []SidebarItem, metaDataMap map[string]ContentMeta) {
+// Items pointing at ids that no longer exist are added to the report, which may be nil.
+func updateSidebarItems(items []SidebarItem, metaDataMap map[string]ContentMeta, location string, report *UpdateReport) {
 	for i := range items {
 		if items[i].Type == "file" {
 			if meta, ok := metaDataMap[items[i].Meta.ID]; ok {
 				items[i].Meta = meta
-			} else {
+			} else if items[i].Meta.ID != "" {
 				blog.Errorf("ID: '%s', not found for SidebarItem: %s", items[i].Meta.ID, items[i].Name)
-				// TODO: webhook message
+				report.addOrphanedItem(location+"/"+items[i].Name, items[i].Meta.ID)
 			}
 		} else if items[i].Type == "folder" {
-			updateSidebarItems(items[i].Contents, metaDataMap)
+			updateSidebarItems(items[i].Contents, metaDataMap, location+"/"+items[i].Name, report)
 		}
 	}
 }
 
-// updateFooterItems
-func updateFooterItems(items []FooterItem, metaDataMap map[string]ContentMeta) {
+// updateFooterItems updates the meta data for all files in the footer.
+// Items pointing at ids that no longer exist are added to the report, which may be nil.
+func updateFooterItems(items []FooterItem, metaDataMap map[string]ContentMeta, report *UpdateReport) {
 	for i := range items {
-		if items[i].Type == "file" {
+		if items[i].Type == "footer-file" {
 			if meta, ok := metaDataMap[items[i].Meta.ID]; ok {
 				items[i].Meta = meta
-			} else {
+			} else if items[i].Meta.ID != "" {
 				blog.Errorf("ID: '%s', not found for FooterItem: %s", items[i].Meta.ID, items[i].Name)
-				// TODO: webhook message
+				report.addOrphanedItem("Footer/"+items[i].Name, items[i].Meta.ID)
 			}
 		}
 	}
@@ -467,85 +755,185 @@ func extractIdFromFile(path string) (string, error) {
 	return strings.TrimSpace(strings.TrimSuffix(parts[1], "-->")), nil
 }
 
-// loadIDs loads and parses the ids.json as well as set rel_path's to MISSING_FILE if the file does not exist or the ID does not match.
-func loadIDs(contentPath string) ([]ContentMeta, error) {
+// loadIDs loads and parses the ids.json as well as set rel_path's to MISSING_FILE if no file has the ID.
+// Ids are namespaced with the name of the content source, see sourceID. Every markdown file is scanned for its ID comment,
+// so pages moved without updating ids.json, or with an ID missing from it, are still loaded. Missing files, duplicate IDs,
+// and files without an ID are added to the report.
+func loadIDs(contentPath, source string, report *UpdateReport) ([]ContentMeta, error) {
 	// read the ids file
 	var fileContent map[string]string
-	if exists, err := files.LoadJSON(filepath.Join(contentPath, ".github", "ids.json"), &fileContent); err != nil {
+	if exists, err := files.LoadJSON(filepath.Join(contentPath, IDS_FILE), &fileContent); err != nil {
 		return nil, err
 	} else if !exists {
 		return nil, fmt.Errorf("ids.json not found")
 	}
-	var metaDatas []ContentMeta
-	for id, relPath := range fileContent {
-		metaDatas = append(metaDatas, ContentMeta{ID: id, RelPath: relPath})
+	found, noID, err := scanIDs(contentPath)
+	if err != nil {
+		return nil, err
 	}
 
-	blog.Debugf("Loaded %d ids", len(metaDatas))
-
-	// ensure rel_path are pointing to a file with the first line being `<!-- ID: TOKEN -->`, if not set to MISSING_FILE
-	for i := range metaDatas {
-		path := filepath.Join(contentPath, metaDatas[i].RelPath)
-		// check if the file exists
-		if exists, err := files.Exists(path); err != nil {
-			return nil, err
-		} else if !exists {
-			metaDatas[i].RelPath = MISSING_FILE
-			continue
-		}
-		fileID, err := extractIdFromFile(path)
-		if err != nil {
-			if err.Error() == "invalid format" {
-				metaDatas[i].RelPath = MISSING_FILE
-				continue
-			} else {
+	registered := make([]string, 0, len(fileContent))
+	for id := range fileContent {
+		registered = append(registered, id)
+	}
+	sort.Strings(registered) // report problems in a stable order
+	var metaDatas []ContentMeta
+	for _, id := range registered {
+		relPath, paths := fileContent[id], found[id]
+		if len(paths) == 0 {
+			if err := addMissingReason(contentPath, source, id, relPath, report); err != nil {
 				return nil, err
 			}
+			metaDatas = append(metaDatas, ContentMeta{ID: sourceID(source, id), RelPath: MISSING_FILE})
+			continue
+		}
+		keep := paths[0]
+		if utils.Contains(filepath.ToSlash(relPath), paths) {
+			keep = filepath.ToSlash(relPath)
+		} else {
+			report.addIDProblem(ID_WARNING, sourceID(source, id), keep, "moved from "+relPath+", run 'intermark ids' to update ids.json")
 		}
-		if fileID != metaDatas[i].ID {
-			metaDatas[i].RelPath = MISSING_FILE
+		metaDatas = append(metaDatas, ContentMeta{ID: sourceID(source, id), RelPath: keep})
+		reportDuplicates(source, id, keep, paths, report)
+	}
+	unregistered := make([]string, 0, len(found))
+	for id := range found {
+		if _, ok := fileContent[id]; !ok {
+			unregistered = append(unregistered, id)
 		}
 	}
+	sort.Strings(unregistered)
+	for _, id := range unregistered {
+		paths := found[id]
+		report.addIDProblem(ID_WARNING, sourceID(source, id), paths[0], "ID isn't in ids.json, run 'intermark ids' to add it")
+		metaDatas = append(metaDatas, ContentMeta{ID: sourceID(source, id), RelPath: paths[0]})
+		reportDuplicates(source, id, paths[0], paths, report)
+	}
+	for _, relPath := range noID {
+		report.addIDProblem(ID_WARNING, "", relPath, "no ID comment on the first line, not loaded, run 'intermark ids' to add one")
+	}
 
+	blog.Debugf("Loaded %d ids", len(metaDatas))
 	return metaDatas, nil
 }
 
-// updateContent updates the content for the given meta data.
-func updateContent(repoPath, commit string, metaData ContentMeta) error {
+// addMissingReason adds why no file has the id to the report.
+func addMissingReason(contentPath, source, id, relPath string, report *UpdateReport) error {
+	path := filepath.Join(contentPath, relPath)
+	if exists, err := files.Exists(path); err != nil {
+		return err
+	} else if !exists {
+		report.addMissingFile(sourceID(source, id), relPath, "file not found")
+		return nil
+	}
+	fileID, err := extractIdFromFile(path)
+	if err != nil && err.Error() != "invalid format" {
+		return err
+	} else if err != nil {
+		report.addMissingFile(sourceID(source, id), relPath, "first line isn't a valid ID comment")
+	} else {
+		report.addMissingFile(sourceID(source, id), relPath, "file has ID '"+fileID+"'")
+	}
+	return nil
+}
+
+// reportDuplicates adds the files that have the same id as the loaded one to the report, they aren't loaded.
+func reportDuplicates(source, id, keep string, paths []string, report *UpdateReport) {
+	for _, path := range paths {
+		if path != keep {
+			report.addIDProblem(ID_ERROR, sourceID(source, id), path, "duplicate of "+keep+", not loaded, run 'intermark ids' to give it a new ID")
+		}
+	}
+}
+
+// loadContent reads the page for the given meta data if its file changed in the source, nil if it didn't.
+// Only the front matter is parsed, see renderContent.
+func loadContent(src *syncedSource, metaData ContentMeta) (*ContentModel, error) {
 	// handle missing pages
 	if metaData.RelPath == MISSING_FILE {
-		blog.Errorf("%s skipped, missing", metaData.ID)
-		// TODO: webhook message
-		return nil
+		blog.Errorf("%s skipped, missing", metaData.ID) // reported by loadIDs
+		return nil, nil
 	}
 	// return if the file has not changed, else set the commit
-	if changed, err := utils.GitFileDiff(repoPath, metaData.RelPath, metaData.Commit); err != nil {
-		return err
-	} else if !changed {
+	if !src.changes.changed(metaData.RelPath, metaData.Commit) {
 		blog.Debugf("%s skipped, no changes since %s", metaData.ID, metaData.Commit)
-		return nil
+		return nil, nil
 	}
-	metaData.Commit = commit
-	// convert the md to html
-	md, err := files.ReadFile(filepath.Join(repoPath, metaData.RelPath))
+	metaData.Commit = src.commit
+	md, err := files.ReadFile(filepath.Join(src.path, metaData.RelPath))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	html, err := utils.MdToHTML(md)
+	content := &ContentModel{ContentMeta: metaData, Source: src.Name, MD: md, Updated: src.commitTime}
+	if src.changes == nil {
+		// full rebuild, keep the commit and updated time of pages whose markdown is the same
+		var old ContentModel
+		if err := DB.Select("md", "commit", "updated").Where("id = ?", metaData.ID).Limit(1).Find(&old).Error; err != nil {
+			return nil, err
+		}
+		if old.MD == md && old.Commit != "" {
+			content.Commit, content.Updated = old.Commit, old.Updated
+		}
+	}
+	// links to the page resolve by its front matter before it's rendered
+	content.Front = peekFrontMatter(md)
+	content.Published = isPublished(content.Front, time.Now())
+	return content, nil
+}
+
+// renderContent sets the html and everything else derived from the markdown of the content.
+func renderContent(content *ContentModel, resolve utils.LinkResolver) error {
+	front, body, frontErr := splitFrontMatter(content.ID, content.MD)
+	links := []string{}
+	html, toc, err := utils.MdToHTML(body, func(id string) (string, string, bool) {
+		id = linkID(content.Source, id)
+		if !utils.Contains(id, links) {
+			links = append(links, id)
+		}
+		url, title, ok := resolve(id)
+		if !ok {
+			blog.Warnf("%s links to '%s', which doesn't exist or isn't published", content.ID, id)
+		}
+		return url, title, ok
+	})
 	if err != nil {
 		return err
 	}
-	// save the content
-	time.Sleep(10 * time.Millisecond) // reduce db load
-	err = DB.Save(&ContentModel{ContentMeta: metaData, HTML: html, MD: md}).Error
-	if err == nil {
-		blog.Debugf("%s updated", metaData.ID)
+	content.HTML, content.TOC, content.Links = html, toc, links
+	content.Front, content.Published = front, isPublished(front, time.Now())
+	content.FrontErr = ""
+	if frontErr != nil {
+		content.FrontErr = frontErr.Error()
 	}
-	return err
+	return nil
+}
+
+// rerenderContent renders all stored content again from its markdown, used when new data is derived from it.
+func rerenderContent() error {
+	var contents []ContentModel
+	if err := DB.Find(&contents).Error; err != nil {
+		return err
+	}
+	blog.Infof("Re-rendering %d pages", len(contents))
+	for i := range contents {
+		if contents[i].RelPath == MISSING_FILE {
+			continue
+		}
+		if err := renderContent(&contents[i], pageResolver(DB, nil)); err != nil {
+			return err
+		}
+		if err := DB.Save(&contents[i]).Error; err != nil {
+			return err
+		}
+		if err := indexContent(DB, &contents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // cleanupContent deletes all content records that are not in the given list of meta data.
-func cleanupContent(metaDatas []ContentMeta) error {
+func cleanupContent(db *gorm.DB, metaDatas []ContentMeta) error {
 	if len(metaDatas) == 0 {
 		blog.Debugf("No ids provided, skipping cleanup")
 		return nil
@@ -556,112 +944,166 @@ func cleanupContent(metaDatas []ContentMeta) error {
 		ids[i] = metaData.ID
 	}
 
-	result := DB.Where("id NOT IN ?", ids).Delete(&ContentModel{})
+	result := db.Where("id NOT IN ?", ids).Delete(&ContentModel{})
 	if result.Error != nil {
 		return result.Error
 	}
 
+	if err := cleanupSearch(db, ids); err != nil {
+		return err
+	}
+	if err := cleanupSlugs(db, ids); err != nil {
+		return err
+	}
+
 	blog.Debugf("Deleted %d records", result.RowsAffected)
 	return nil
 }
 
-// updateAssets updates the assets in the database and data/assets directory.
-func updateAssets(commit string) error {
-	AssetsMutex.Lock()
-	defer AssetsMutex.Unlock()
-
-	contentAssetDir := filepath.Join(CONTENT_REPO_PATH, utils.Config.ContentRepo.AssetsDir)
-	if exists, err := files.Exists(contentAssetDir); err != nil {
-		return err
-	} else if !exists {
-		blog.Warnf("Content asset directory not found: %s", contentAssetDir)
-		return nil
-	}
-
-	// ensure the data/assets directory exists
-	if err := files.EnsureDirs(filepath.Join("data", "assets")); err != nil {
-		return err
+// stageAssets stages the assets of every source in STAGED_ASSETS_PATH, see swapStaged, and returns them for saveAssets.
+// Assets of additional sources are placed under their mount. Only new and changed assets are copied again.
+func stageAssets(db *gorm.DB, synced []*syncedSource) ([]AssetModel, error) {
+	// start the staged directory as hard links to the current assets, so unchanged ones aren't copied
+	if exists, err := files.Exists(ASSETS_PATH); err != nil {
+		return nil, err
+	} else if exists {
+		if err := files.LinkDir(ASSETS_PATH, STAGED_ASSETS_PATH); err != nil {
+			return nil, err
+		}
+	} else if err := files.CleanDir(STAGED_ASSETS_PATH); err != nil {
+		return nil, err
 	}
 
-	var assets []AssetModel
-	if err := DB.Find(&assets).Error; err != nil {
-		return err
+	var current []AssetModel
+	if err := db.Find(&current).Error; err != nil {
+		return nil, err
 	}
 
-	// get all asset paths in the content repo
-	var err error
-	var contentRepoAssetPaths []string
-	if contentRepoAssetPaths, err = files.ListAllFiles(contentAssetDir); err != nil {
-		return err
+	// get all asset paths in the content sources, sources without an asset directory keep their current assets
+	type sourceAsset struct {
+		src     *syncedSource
+		relPath string // path in the source
 	}
-	for i, path := range contentRepoAssetPaths {
-		if relPath, err := filepath.Rel(CONTENT_REPO_PATH, path); err != nil {
-			return err
-		} else {
-			contentRepoAssetPaths[i] = relPath
+	found := make(map[string]sourceAsset)
+	skipped := make(map[string]bool)
+	for _, src := range synced {
+		contentAssetDir := filepath.Join(src.path, src.AssetsDir)
+		if exists, err := files.Exists(contentAssetDir); err != nil {
+			return nil, err
+		} else if !exists {
+			blog.Warnf("Content asset directory not found: %s", contentAssetDir)
+			skipped[src.Name] = true
+			continue
+		}
+		paths, err := files.ListAllFiles(contentAssetDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			relPath, err := filepath.Rel(src.path, path)
+			if err != nil {
+				return nil, err
+			}
+			found[filepath.Join(filepath.FromSlash(src.Mount), relPath)] = sourceAsset{src: src, relPath: relPath}
 		}
 	}
 
-	// remove assets from AssetModel slice and data/assets/ that no longer in the content repo
-	for i := len(assets) - 1; i >= 0; i-- {
-		if !utils.Contains(assets[i].ID, contentRepoAssetPaths) {
-			target := filepath.Join("data", "assets", assets[i].ID)
+	// remove assets from the staged assets that are no longer in their source, keeping the commits of the rest
+	var assets []AssetModel
+	commits := make(map[string]string, len(current))
+	for _, asset := range current {
+		if skipped[asset.Source] {
+			assets = append(assets, asset)
+		} else if _, ok := found[asset.ID]; ok {
+			commits[asset.ID] = asset.Commit
+		} else {
+			target := filepath.Join(STAGED_ASSETS_PATH, asset.ID)
 			if exists, err := files.Exists(target); err != nil {
-				return err
+				return nil, err
 			} else if exists {
-				if err = os.Remove(filepath.Join("data", "assets", assets[i].ID)); err != nil {
+				if err = os.Remove(target); err != nil {
 					blog.Errorf("Error removing asset: %v", err)
 				}
 			}
-			assets = append(assets[:i], assets[i+1:]...)
 		}
 	}
 
-	// add new assets to AssetModel slice, leave commit as empty string
-	for _, path := range contentRepoAssetPaths {
-		found := false
-		for _, asset := range assets {
-			if asset.ID == path {
-				found = true
-				break
-			}
-		}
-		if !found {
-			assets = append(assets, AssetModel{ID: path, Commit: ""})
-		}
-	}
-
-	// for each if diff copy to data/assets/ and update commit
-	for i := range assets {
-		var err error
-		var changed bool
-		if changed, err = utils.GitFileDiff(CONTENT_REPO_PATH, assets[i].ID, assets[i].Commit); err != nil {
-			return err
-		}
-		var exists bool
-		dst := filepath.Join("data", "assets", assets[i].ID)
-		if exists, err = files.Exists(dst); err != nil {
-			return err
+	// for each if diff copy to the staged assets and update commit, new assets have no commit yet
+	for id, item := range found {
+		asset := AssetModel{ID: id, Source: item.src.Name, Commit: commits[id]}
+		dst := filepath.Join(STAGED_ASSETS_PATH, id)
+		exists, err := files.Exists(dst)
+		if err != nil {
+			return nil, err
 		}
-		if changed || !exists {
-			assets[i].Commit = commit
-			src := filepath.Join(CONTENT_REPO_PATH, assets[i].ID)
+		if item.src.changes.changed(item.relPath, asset.Commit) || !exists {
+			asset.Commit = item.src.commit
+			// replace the link instead of writing through it to the current asset
+			if exists {
+				if err := os.Remove(dst); err != nil {
+					return nil, err
+				}
+			}
+			src := filepath.Join(item.src.path, item.relPath)
 			if err := files.CopyFile(src, dst); err != nil {
-				return err
+				return nil, err
 			}
 			blog.Debugf("Asset updated, src: %s, dst: %s", src, dst)
 		} else {
-			blog.Debugf("Asset %s skipped, no changes since %s", dst, assets[i].Commit)
+			blog.Debugf("Asset %s skipped, no changes since %s", dst, asset.Commit)
 		}
+		assets = append(assets, asset)
 	}
 
-	// update the db
-	if err := DB.Exec("DELETE FROM asset_models").Error; err != nil {
+	return assets, nil
+}
+
+// saveAssets replaces the assets in the database.
+func saveAssets(db *gorm.DB, assets []AssetModel) error {
+	if err := db.Exec("DELETE FROM asset_models").Error; err != nil {
 		return err
 	}
-	if err := DB.Create(&assets).Error; err != nil {
+	if len(assets) == 0 {
+		return nil
+	}
+	return db.Create(&assets).Error
+}
+
+// swapStaged replaces the assets and CSS with the staged ones from an update.
+func swapStaged() error {
+	AssetsMutex.Lock()
+	defer AssetsMutex.Unlock()
+	if exists, err := files.Exists(STAGED_ASSETS_PATH); err != nil {
 		return err
+	} else if exists {
+		old := ASSETS_PATH + ".old"
+		if err := os.RemoveAll(old); err != nil {
+			return err
+		}
+		if err := os.Rename(ASSETS_PATH, old); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(STAGED_ASSETS_PATH, ASSETS_PATH); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(old); err != nil {
+			blog.Errorf("Error removing old assets: %v", err)
+		}
 	}
+	tailwindMutex.Lock()
+	defer tailwindMutex.Unlock()
+	return os.Rename(STAGED_CSS_PATH, CSS_PATH)
+}
 
-	return nil
+// discardStaged removes the staged assets and CSS of a failed update, and puts back the html tailwind scans.
+func discardStaged() {
+	if err := os.RemoveAll(STAGED_ASSETS_PATH); err != nil {
+		blog.Errorf("Error removing staged assets: %v", err)
+	}
+	if err := os.Remove(STAGED_CSS_PATH); err != nil && !os.IsNotExist(err) {
+		blog.Errorf("Error removing staged CSS: %v", err)
+	}
+	if err := runTailwind(DB, nil, CSS_PATH, false); err != nil {
+		blog.Errorf("Error running tailwindcss: %v", err)
+	}
 }
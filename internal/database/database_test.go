@@ -0,0 +1,75 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+)
+
+func init() {
+	blog.Init("", blog.NONE) // consume log messages so they don't block once the buffer fills
+	utils.InitMarkdownConverter()
+}
+
+// setupTestDB opens a new database in a temporary working directory, closed when the test ends.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("data", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	config := utils.Config
+	utils.Config = utils.ImConfig{Title: "Test", EditPassword: "password"}
+	utils.Config.ContentRepo.Branch = "main"
+	utils.Config.ContentRepo.AssetsDir = "assets"
+	t.Cleanup(func() {
+		Close()
+		DB = nil
+		utils.Config = config
+		os.Chdir(wd)
+	})
+	DB = nil
+	Init()
+}
+
+// addTestPage renders and stores a page like an update would, then updates the slugs and published pages.
+func addTestPage(t *testing.T, id, relPath, md string) ContentModel {
+	t.Helper()
+	content := ContentModel{ContentMeta: ContentMeta{ID: id, RelPath: relPath, Commit: "test"}, MD: md, Updated: time.Now()}
+	if err := renderContent(&content, pageResolver(DB, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Save(&content).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := indexContent(DB, &content); err != nil {
+		t.Fatal(err)
+	}
+	metaDatas, err := GetMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := storedSlugOverrides(DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updateSlugs(DB, metaDatas, overrides); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSlugCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadUnpublishedCache(); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
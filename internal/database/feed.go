@@ -0,0 +1,61 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const DEFAULT_FEED_SIZE = 20
+
+// DisplayTitle returns the front matter title of the page, or one made from the file name,
+// e.g. "guides/Getting Started.md" -> "Getting Started".
+func (c *ContentModel) DisplayTitle() string {
+	if c.Front.Title != "" {
+		return c.Front.Title
+	}
+	name := filepath.Base(filepath.FromSlash(c.RelPath))
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// GetRecentContent retrieves the most recently changed pages, newest first.
+func GetRecentContent(limit int) ([]ContentModel, error) {
+	if limit <= 0 {
+		limit = DEFAULT_FEED_SIZE
+	}
+	var contents []ContentModel
+	err := DB.Omit("md").
+		Where("rel_path <> ? AND published = ?", MISSING_FILE, true).
+		Order("updated DESC").Limit(limit).Find(&contents).Error
+	if err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// backfillUpdated sets the updated time of pages that don't have one from their commit. Local directories are skipped.
+func backfillUpdated(db *gorm.DB) error {
+	var commits []struct {
+		Source string
+		Commit string
+	}
+	if err := db.Model(&ContentModel{}).Where("(updated IS NULL OR updated = ?) AND `commit` <> ''", time.Time{}).Distinct("source", "commit").Find(&commits).Error; err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		if source, ok := utils.Config.ContentSource(commit.Source); ok && source.Path != "" {
+			continue // no history to read, pages of local directories get the time they're synced
+		}
+		commitTime, err := utils.GitCommitTime(sourcePath(commit.Source), commit.Commit)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&ContentModel{}).Where("source = ? AND `commit` = ?", commit.Source, commit.Commit).Update("updated", commitTime).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"strings"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// splitFrontMatter separates the front matter from a page's markdown, keeping the ID line.
+// Invalid front matter is left in place so the page still renders, the error is returned for the update report.
+func splitFrontMatter(id, md string) (utils.FrontMatter, string, error) {
+	idLine, rest, _ := strings.Cut(md, "\n")
+	front, body, err := utils.SplitFrontMatter(rest)
+	if err != nil {
+		blog.Errorf("%s: %v", id, err)
+	}
+	if body == rest {
+		return front, md, err
+	}
+	return front, idLine + "\n" + body, err
+}
+
+// peekFrontMatter returns the front matter of a page's markdown without reporting errors, see splitFrontMatter.
+func peekFrontMatter(md string) utils.FrontMatter {
+	_, rest, _ := strings.Cut(md, "\n")
+	front, _, _ := utils.SplitFrontMatter(rest)
+	return front
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatterKeepsID(t *testing.T) {
+	front, md, err := splitFrontMatter("aaa", "<!-- ID: aaa -->\n---\ntitle: Hi\n---\n# Body\n")
+	if err != nil || front.Title != "Hi" || md != "<!-- ID: aaa -->\n# Body\n" {
+		t.Errorf("splitFrontMatter() = %+v, %q, %v", front, md, err)
+	}
+	invalid := "<!-- ID: aaa -->\n---\ntitle: [oops\n---\n# Body\n"
+	if front, md, err = splitFrontMatter("aaa", invalid); err == nil || front.Title != "" || md != invalid {
+		t.Errorf("splitFrontMatter(invalid) = %+v, %q, %v", front, md, err)
+	}
+}
+
+func TestCheckFrontMatter(t *testing.T) {
+	setupTestDB(t)
+	bad := addTestPage(t, "bad", "bad.md", "<!-- ID: bad -->\n---\ndraft: maybe\n---\n# Bad\n")
+	addTestPage(t, "good", "good.md", "<!-- ID: good -->\n---\ntitle: Good\n---\n")
+	addTestPage(t, "gone", "gone.md", "<!-- ID: gone -->\n+++\ntitle = \n+++\n")
+	if bad.FrontErr == "" || !bad.Published || !strings.Contains(bad.HTML, "draft: maybe") {
+		t.Errorf("page with invalid front matter = %+v", bad)
+	}
+
+	metaDataMap := map[string]ContentMeta{
+		"bad":  {ID: "bad", RelPath: "bad.md"},
+		"good": {ID: "good", RelPath: "good.md"},
+		"gone": {ID: "gone", RelPath: MISSING_FILE},
+	}
+	report := &UpdateReport{}
+	if err := checkFrontMatter(DB, report, metaDataMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.FrontMatter) != 1 || report.FrontMatter[0].ID != "bad" || report.FrontMatter[0].RelPath != "bad.md" || report.FrontMatter[0].Error != bad.FrontErr {
+		t.Errorf("front matter problems = %+v", report.FrontMatter)
+	}
+	if !report.HasProblems() {
+		t.Error("HasProblems() = false")
+	}
+
+	// fixing the page clears the problem
+	addTestPage(t, "bad", "bad.md", "<!-- ID: bad -->\n---\ndraft: false\n---\n# Bad\n")
+	report = &UpdateReport{}
+	if err := checkFrontMatter(DB, report, metaDataMap); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report, &UpdateReport{}) {
+		t.Errorf("report = %+v", report)
+	}
+}
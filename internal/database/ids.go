@@ -0,0 +1,153 @@
+package database
+
+import (
+	"fmt"
+	"intermark/internal/files"
+	"intermark/internal/utils"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IDS_FILE lists the id and path of every page in a content source, relative to its root.
+var IDS_FILE = filepath.Join(".github", "ids.json")
+
+// IDChange is a change AssignIDs made to a content checkout.
+type IDChange struct {
+	ID     string
+	Path   string // slash separated
+	Change string // "added", "registered" (had an id missing from ids.json), "moved", or "reissued" (copy of another file)
+}
+
+// scanIDs reads the id comment on the first line of every markdown file in the checkout, skipping hidden directories.
+// Returns the slash separated paths of the files with each id, and the files without an id, sorted.
+func scanIDs(contentPath string) (map[string][]string, []string, error) {
+	found := make(map[string][]string)
+	var noID []string
+	err := filepath.WalkDir(contentPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if entry.IsDir() {
+			return utils.Ternary(path != contentPath && strings.HasPrefix(entry.Name(), "."), filepath.SkipDir, nil)
+		} else if filepath.Ext(path) != ".md" {
+			return nil
+		}
+		relPath, err := filepath.Rel(contentPath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if id, err := extractIdFromFile(path); err == nil && id != "" {
+			found[id] = append(found[id], relPath)
+		} else if err != nil && err.Error() != "invalid format" {
+			return err
+		} else {
+			noID = append(noID, relPath)
+		}
+		return nil
+	})
+	return found, noID, err // WalkDir visits files in lexical order
+}
+
+// AssignIDs gives every markdown file in the checkout an id comment and rewrites ids.json to match, for content
+// repositories without the GitHub workflow that does this. Files are tracked by their id comment, so moved files keep
+// their id. If files share an id, e.g. one was copied, the one at the registered path keeps it and the rest get new ones.
+// Nothing is changed if an id in ids.json has no file, the page was deleted without removing it.
+func AssignIDs(contentPath string) ([]IDChange, error) {
+	idsPath := filepath.Join(contentPath, IDS_FILE)
+	registered := make(map[string]string)
+	if _, err := files.LoadJSON(idsPath, &registered); err != nil {
+		return nil, fmt.Errorf("error loading %s: %w", IDS_FILE, err)
+	}
+	found, noID, err := scanIDs(contentPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ids: %w", err)
+	}
+
+	var changes []IDChange
+	ids := make(map[string]string)
+	copies := make(map[string]bool)
+	for id, paths := range found {
+		keep := paths[0]
+		if utils.Contains(registered[id], paths) {
+			keep = registered[id]
+		} else if _, ok := registered[id]; ok {
+			changes = append(changes, IDChange{ID: id, Path: keep, Change: "moved"})
+		} else {
+			changes = append(changes, IDChange{ID: id, Path: keep, Change: "registered"})
+		}
+		ids[id] = keep
+		for _, path := range paths {
+			if path != keep {
+				copies[path] = true
+				noID = append(noID, path)
+			}
+		}
+	}
+	// like the workflow, deleting a page is confirmed by removing its id from ids.json in the same commit
+	var deleted []string
+	for id, path := range registered {
+		if _, ok := ids[id]; !ok {
+			deleted = append(deleted, fmt.Sprintf("%s (%s)", id, path))
+		}
+	}
+	if len(deleted) > 0 {
+		sort.Strings(deleted)
+		return nil, fmt.Errorf("no file has these ids, remove them from %s to confirm the deletion: %s", IDS_FILE, strings.Join(deleted, ", "))
+	}
+	sort.Strings(noID)
+	for _, path := range noID {
+		id, err := newID(ids, registered)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeIDComment(filepath.Join(contentPath, path), id); err != nil {
+			return nil, err
+		}
+		ids[id] = path
+		changes = append(changes, IDChange{ID: id, Path: path, Change: utils.Ternary(copies[path], "reissued", "added")})
+	}
+
+	if err := files.EnsureDirs(filepath.Dir(idsPath)); err != nil {
+		return nil, err
+	}
+	if err := files.SaveJSON(idsPath, ids, 0644); err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// newID returns a random id that isn't in use or registered.
+func newID(ids, registered map[string]string) (string, error) {
+	for {
+		id, err := utils.GenRandomString(9)
+		if err != nil {
+			return "", err
+		}
+		_, used := ids[id]
+		_, old := registered[id]
+		if !used && !old {
+			return id, nil
+		}
+	}
+}
+
+// writeIDComment sets the first line of the markdown file to the id comment, replacing an existing one.
+func writeIDComment(path, id string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	md, err := files.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	newline := utils.Ternary(strings.Contains(md, "\r\n"), "\r\n", "\n")
+	if first, rest, _ := strings.Cut(md, "\n"); strings.HasPrefix(first, "<!-- ID") {
+		md = rest
+	}
+	return os.WriteFile(path, []byte("<!-- ID: "+id+" -->"+newline+md), info.Mode().Perm())
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"intermark/internal/files"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssignIDs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		".github/ids.json": `{"aaa": "a.md", "bbb": "b.md"}`,
+		"a.md":             "<!-- ID: aaa -->\n# A\n",
+		"copy.md":          "<!-- ID: aaa -->\n# Copy of A\n",
+		"guides/b.md":      "<!-- ID: bbb -->\n# B\n",
+		"c.md":             "<!-- ID: ccc -->\n# C\n",
+		"new.md":           "# New\r\nWindows line endings\r\n",
+		".hidden/skip.md":  "# Skipped\n",
+		"notes.txt":        "not a page",
+	})
+	changes, err := AssignIDs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files keep their ids, copies and files without one get new ones
+	var ids map[string]string
+	if _, err := files.LoadJSON(filepath.Join(dir, IDS_FILE), &ids); err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]IDChange)
+	for _, change := range changes {
+		byPath[change.Path] = change
+		if ids[change.ID] != change.Path {
+			t.Errorf("ids.json has %s at %q, want %q", change.ID, ids[change.ID], change.Path)
+		}
+	}
+	if len(changes) != 4 || byPath["guides/b.md"].Change != "moved" || byPath["c.md"].Change != "registered" ||
+		byPath["copy.md"].Change != "reissued" || byPath["new.md"].Change != "added" {
+		t.Errorf("changes = %+v", changes)
+	}
+	if len(ids) != 5 || ids["aaa"] != "a.md" || ids["bbb"] != "guides/b.md" || ids["ccc"] != "c.md" {
+		t.Errorf("ids = %v", ids)
+	}
+	if md, _ := files.ReadFile(filepath.Join(dir, "copy.md")); md != "<!-- ID: "+byPath["copy.md"].ID+" -->\n# Copy of A\n" {
+		t.Errorf("copy.md = %q", md)
+	}
+	if md, _ := files.ReadFile(filepath.Join(dir, "new.md")); md != "<!-- ID: "+byPath["new.md"].ID+" -->\r\n# New\r\nWindows line endings\r\n" {
+		t.Errorf("new.md = %q", md)
+	}
+	if md, _ := files.ReadFile(filepath.Join(dir, ".hidden", "skip.md")); strings.Contains(md, "ID") {
+		t.Errorf("hidden file given an id: %q", md)
+	}
+
+	// running again changes nothing
+	if again, err := AssignIDs(dir); err != nil || len(again) != 0 {
+		t.Errorf("AssignIDs() again = %+v, %v", again, err)
+	}
+
+	// deleting a page has to be confirmed in ids.json
+	if err := os.Remove(filepath.Join(dir, "c.md")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AssignIDs(dir); err == nil || !strings.Contains(err.Error(), "ccc (c.md)") {
+		t.Errorf("AssignIDs() with a deleted page = %v", err)
+	}
+	var after map[string]string
+	if _, err := files.LoadJSON(filepath.Join(dir, IDS_FILE), &after); err != nil || !reflect.DeepEqual(after, ids) {
+		t.Errorf("ids.json changed: %v, %v", after, err)
+	}
+}
+
+func TestAssignIDsWithoutIDsFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.md": "# A\n"})
+	changes, err := AssignIDs(dir)
+	if err != nil || len(changes) != 1 || changes[0].Change != "added" {
+		t.Fatalf("AssignIDs() = %+v, %v", changes, err)
+	}
+	if id, err := extractIdFromFile(filepath.Join(dir, "a.md")); err != nil || id != changes[0].ID {
+		t.Errorf("id = %q, %v", id, err)
+	}
+}
+
+func TestLoadIDs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		".github/ids.json": `{"aaa": "a.md", "bbb": "old/b.md", "ccc": "gone.md", "ddd": "wrong.md", "fff": "bad.md"}`,
+		"a.md":             "<!-- ID: aaa -->\n",
+		"dup.md":           "<!-- ID: aaa -->\n",
+		"b.md":             "<!-- ID: bbb -->\n",
+		"wrong.md":         "<!-- ID: eee -->\n",
+		"bad.md":           "# No ID\n",
+	})
+	report := &UpdateReport{}
+	metaDatas, err := loadIDs(dir, "blog", report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// registered ids first, missing files keep their id, ids are namespaced by the source
+	wantMeta := []ContentMeta{
+		{ID: "blog:aaa", RelPath: "a.md"},
+		{ID: "blog:bbb", RelPath: "b.md"},
+		{ID: "blog:ccc", RelPath: MISSING_FILE},
+		{ID: "blog:ddd", RelPath: MISSING_FILE},
+		{ID: "blog:fff", RelPath: MISSING_FILE},
+		{ID: "blog:eee", RelPath: "wrong.md"},
+	}
+	if !reflect.DeepEqual(metaDatas, wantMeta) {
+		t.Errorf("metaDatas =\n%+v\nwant\n%+v", metaDatas, wantMeta)
+	}
+	wantMissing := []MissingFile{
+		{ID: "blog:ccc", RelPath: "gone.md", Reason: "file not found"},
+		{ID: "blog:ddd", RelPath: "wrong.md", Reason: "file has ID 'eee'"},
+		{ID: "blog:fff", RelPath: "bad.md", Reason: "first line isn't a valid ID comment"},
+	}
+	if !reflect.DeepEqual(report.MissingFiles, wantMissing) {
+		t.Errorf("missing files =\n%+v\nwant\n%+v", report.MissingFiles, wantMissing)
+	}
+	wantProblems := []IDProblem{
+		{Level: ID_ERROR, ID: "blog:aaa", RelPath: "dup.md", Problem: "duplicate of a.md, not loaded, run 'intermark ids' to give it a new ID"},
+		{Level: ID_WARNING, ID: "blog:bbb", RelPath: "b.md", Problem: "moved from old/b.md, run 'intermark ids' to update ids.json"},
+		{Level: ID_WARNING, ID: "blog:eee", RelPath: "wrong.md", Problem: "ID isn't in ids.json, run 'intermark ids' to add it"},
+		{Level: ID_WARNING, RelPath: "bad.md", Problem: "no ID comment on the first line, not loaded, run 'intermark ids' to add one"},
+	}
+	if !reflect.DeepEqual(report.IDProblems, wantProblems) {
+		t.Errorf("id problems =\n%+v\nwant\n%+v", report.IDProblems, wantProblems)
+	}
+
+	// the report is optional, ids.json isn't
+	if _, err := loadIDs(dir, "", nil); err != nil {
+		t.Errorf("loadIDs() without a report = %v", err)
+	}
+	if _, err := loadIDs(t.TempDir(), "", report); err == nil {
+		t.Error("loaded ids without ids.json")
+	}
+}
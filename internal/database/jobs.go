@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"intermark/internal/utils"
+	"sync"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// job statuses
+const (
+	JOB_QUEUED  = "queued"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+// update stages, in order
+const (
+	STAGE_FETCH    = "fetch"
+	STAGE_IDS      = "ids"
+	STAGE_CONTENT  = "content"
+	STAGE_ASSETS   = "assets"
+	STAGE_TAILWIND = "tailwind"
+	STAGE_SAVE     = "save"
+	STAGE_CLEANUP  = "cleanup"
+)
+
+const MAX_UPDATE_JOBS = 50 // older finished jobs are forgotten
+
+// UpdateJob is a queued run of Update. Triggers arriving while a job is still queued join it instead of adding another.
+type UpdateJob struct {
+	ID       string    `json:"ID"`
+	Status   string    `json:"Status"`
+	Stage    string    `json:"Stage"` // current stage while running, last stage reached once finished
+	Error    string    `json:"Error"`
+	Triggers int       `json:"Triggers"` // number of requests coalesced into this job
+	Queued   time.Time `json:"Queued"`
+	Started  time.Time `json:"Started"`
+	Finished time.Time `json:"Finished"`
+}
+
+var (
+	jobsMutex  = sync.Mutex{}
+	jobs       = make(map[string]*UpdateJob)
+	jobOrder   []string   // ids oldest first
+	pendingJob *UpdateJob // queued job not yet picked up by the worker
+	jobSignal  = make(chan struct{}, 1)
+)
+
+// QueueUpdate queues an update and returns a copy of its job. If a job is already waiting it's returned instead.
+func QueueUpdate() (UpdateJob, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	if pendingJob != nil {
+		pendingJob.Triggers++
+		blog.Debugf("Update request coalesced into job %s", pendingJob.ID)
+		return *pendingJob, nil
+	}
+	id, err := utils.GenRandomString(9)
+	if err != nil {
+		blog.Errorf("Error generating job id: %v", err)
+		return UpdateJob{}, errors.New("error generating job id")
+	}
+	job := &UpdateJob{ID: id, Status: JOB_QUEUED, Triggers: 1, Queued: time.Now()}
+	jobs[id] = job
+	jobOrder = append(jobOrder, id)
+	pruneJobs()
+	pendingJob = job
+	select {
+	case jobSignal <- struct{}{}:
+	default: // the worker is already signaled
+	}
+	return *job, nil
+}
+
+// GetUpdateJob returns a copy of the job with the given id, ok is false if it's unknown.
+func GetUpdateJob(id string) (UpdateJob, bool) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job, ok := jobs[id]
+	if !ok {
+		return UpdateJob{}, false
+	}
+	return *job, true
+}
+
+// StartUpdateWorker runs queued updates one at a time in the background.
+func StartUpdateWorker() {
+	go func() {
+		for range jobSignal {
+			jobsMutex.Lock()
+			job := pendingJob
+			pendingJob = nil
+			if job != nil {
+				job.Status, job.Started = JOB_RUNNING, time.Now()
+			}
+			jobsMutex.Unlock()
+			if job == nil {
+				continue
+			}
+			err := runUpdate(job)
+			jobsMutex.Lock()
+			job.Finished = time.Now()
+			if err != nil {
+				job.Status, job.Error = JOB_FAILED, err.Error()
+			} else {
+				job.Status = JOB_DONE
+			}
+			jobsMutex.Unlock()
+		}
+	}()
+}
+
+// setStage records the stage the job reached, the job may be nil.
+func (j *UpdateJob) setStage(stage string) {
+	if j == nil {
+		return
+	}
+	blog.Debugf("Update job %s: %s", j.ID, stage)
+	jobsMutex.Lock()
+	j.Stage = stage
+	jobsMutex.Unlock()
+}
+
+// pruneJobs forgets the oldest finished jobs over the limit. jobsMutex must be held.
+func pruneJobs() {
+	for len(jobOrder) > MAX_UPDATE_JOBS {
+		oldest := jobs[jobOrder[0]]
+		if oldest.Status == JOB_QUEUED || oldest.Status == JOB_RUNNING {
+			return
+		}
+		delete(jobs, jobOrder[0])
+		jobOrder = jobOrder[1:]
+	}
+}
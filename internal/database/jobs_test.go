@@ -0,0 +1,103 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// resetJobs clears the job queue, restored when the test ends. The worker isn't running in these tests.
+func resetJobs(t *testing.T) {
+	t.Helper()
+	jobsMutex.Lock()
+	oldJobs, oldOrder, oldPending := jobs, jobOrder, pendingJob
+	jobs, jobOrder, pendingJob = make(map[string]*UpdateJob), nil, nil
+	jobsMutex.Unlock()
+	t.Cleanup(func() {
+		jobsMutex.Lock()
+		jobs, jobOrder, pendingJob = oldJobs, oldOrder, oldPending
+		jobsMutex.Unlock()
+		select {
+		case <-jobSignal:
+		default:
+		}
+	})
+}
+
+func TestQueueUpdate(t *testing.T) {
+	resetJobs(t)
+	first, err := QueueUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Status != JOB_QUEUED || first.Triggers != 1 || first.ID == "" {
+		t.Errorf("job = %+v", first)
+	}
+	// triggers before the worker picks it up join the queued job
+	second, err := QueueUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID || second.Triggers != 2 {
+		t.Errorf("job = %+v", second)
+	}
+	if len(jobSignal) != 1 {
+		t.Errorf("%d signals pending", len(jobSignal))
+	}
+	job, ok := GetUpdateJob(first.ID)
+	if !ok || job.Triggers != 2 {
+		t.Errorf("GetUpdateJob() = %+v, %v", job, ok)
+	}
+	if _, ok := GetUpdateJob("unknown"); ok {
+		t.Error("unknown job found")
+	}
+
+	// once picked up, the next trigger queues a new job
+	jobsMutex.Lock()
+	pendingJob.Status = JOB_RUNNING
+	pendingJob = nil
+	jobsMutex.Unlock()
+	third, err := QueueUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.ID == first.ID || third.Triggers != 1 {
+		t.Errorf("job = %+v", third)
+	}
+
+	// stages are recorded on the job, nil jobs are ignored
+	jobsMutex.Lock()
+	running := jobs[first.ID]
+	jobsMutex.Unlock()
+	running.setStage(STAGE_CONTENT)
+	if job, _ := GetUpdateJob(first.ID); job.Stage != STAGE_CONTENT {
+		t.Errorf("stage = %q", job.Stage)
+	}
+	var none *UpdateJob
+	none.setStage(STAGE_CONTENT)
+}
+
+func TestPruneJobs(t *testing.T) {
+	resetJobs(t)
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	add := func(id, status string) {
+		jobs[id] = &UpdateJob{ID: id, Status: status, Queued: time.Now()}
+		jobOrder = append(jobOrder, id)
+	}
+	add("running", JOB_RUNNING)
+	for i := 0; i < MAX_UPDATE_JOBS; i++ {
+		add(fmt.Sprint(i), JOB_DONE)
+	}
+	// an unfinished job holds back pruning
+	pruneJobs()
+	if len(jobOrder) != MAX_UPDATE_JOBS+1 {
+		t.Errorf("%d jobs kept", len(jobOrder))
+	}
+	jobs["running"].Status = JOB_FAILED
+	add("new", JOB_QUEUED)
+	pruneJobs()
+	if len(jobOrder) != MAX_UPDATE_JOBS || jobOrder[0] != "1" || jobs["running"] != nil || jobs["0"] != nil || jobs["new"] == nil {
+		t.Errorf("jobs kept: %v", jobOrder)
+	}
+}
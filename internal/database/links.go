@@ -0,0 +1,102 @@
+package database
+
+import (
+	"errors"
+	"intermark/internal/utils"
+
+	"github.com/Data-Corruption/blog"
+	"gorm.io/gorm"
+)
+
+// pageResolver returns a resolver for links to page ids, reading titles and urls from db. If metaDataMap is given,
+// it decides which pages exist, so pages that haven't been stored yet during an update still resolve.
+// Drafts and scheduled pages don't resolve, the html is public so it mustn't show their titles or urls.
+func pageResolver(db *gorm.DB, metaDataMap map[string]ContentMeta) utils.LinkResolver {
+	return func(id string) (string, string, bool) {
+		var target ContentModel
+		err := db.Select("id", "rel_path", "front_title", "published").Where("id = ?", id).First(&target).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			blog.Errorf("Error resolving link to '%s': %v", id, err)
+		}
+		if err == nil && !target.Published {
+			return "", "", false
+		}
+		if metaDataMap != nil {
+			meta, ok := metaDataMap[id]
+			if !ok || meta.RelPath == MISSING_FILE {
+				return "", "", false
+			}
+			target.ContentMeta = meta // the db may have the path from before a move
+		} else if err != nil {
+			return "", "", false
+		}
+		return pageURL(db, id), target.DisplayTitle(), true
+	}
+}
+
+// stagedResolver is pageResolver for an update that hasn't been written yet. Pages loaded for it are read from contents,
+// the rest from db, and urls use the slugs it will store.
+func stagedResolver(db *gorm.DB, metaDataMap map[string]ContentMeta, contents map[string]*ContentModel, slugs map[string]string) utils.LinkResolver {
+	stored := pageResolver(db, metaDataMap)
+	return func(id string) (string, string, bool) {
+		var title string
+		if content, ok := contents[id]; ok {
+			if !content.Published {
+				return "", "", false
+			}
+			title = content.DisplayTitle()
+		} else if _, title, ok = stored(id); !ok {
+			return "", "", false
+		}
+		if slug, ok := slugs[id]; ok {
+			return "/p/" + slug, title, true
+		}
+		return "/page?id=" + id, title, true
+	}
+}
+
+// linkingIDs returns the ids of pages in db that link to any of the given ids.
+func linkingIDs(db *gorm.DB, ids map[string]bool) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var candidates []ContentModel
+	if err := db.Select("id", "links").Where("links IS NOT NULL AND links <> ? AND links <> ?", "null", "[]").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	var linking []string
+	for _, candidate := range candidates {
+		for _, id := range candidate.Links {
+			if ids[id] {
+				linking = append(linking, candidate.ID)
+				break
+			}
+		}
+	}
+	return linking, nil
+}
+
+// rerenderLinking renders pages that link to any of the given ids again, so their links point at the current url and title.
+func rerenderLinking(db *gorm.DB, ids map[string]bool, resolve utils.LinkResolver) error {
+	linking, err := linkingIDs(db, ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range linking {
+		var content ContentModel
+		if err := db.Where("id = ?", id).First(&content).Error; err != nil {
+			return err
+		}
+		if err := renderContent(&content, resolve); err != nil {
+			return err
+		}
+		if err := db.Save(&content).Error; err != nil {
+			return err
+		}
+		if err := indexContent(db, &content); err != nil {
+			return err
+		}
+		blog.Debugf("%s re-rendered, linked page changed", content.ID)
+	}
+	return nil
+}
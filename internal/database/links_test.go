@@ -0,0 +1,42 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRerenderLinking(t *testing.T) {
+	setupTestDB(t)
+	addTestPage(t, "target", "target.md", "<!-- ID: target -->\n---\ntitle: Old Title\n---\n")
+	linking := addTestPage(t, "linking", "linking.md", "<!-- ID: linking -->\n[[target]] [[target]] [[missing]]\n")
+	addTestPage(t, "other", "other.md", "<!-- ID: other -->\nNo links\n")
+	if !reflect.DeepEqual(linking.Links, []string{"target", "missing"}) {
+		t.Errorf("links = %v", linking.Links)
+	}
+	if !strings.Contains(linking.HTML, `<a href="/p/target">Old Title</a>`) {
+		t.Fatalf("html = %s", linking.HTML)
+	}
+
+	// retitle the target, pages linking to it pick up the new title
+	addTestPage(t, "target", "target.md", "<!-- ID: target -->\n---\ntitle: New Title\n---\n")
+	if err := rerenderLinking(DB, map[string]bool{"target": true}, pageResolver(DB, nil)); err != nil {
+		t.Fatal(err)
+	}
+	content, err := GetContent("linking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content.HTML, `<a href="/p/target">New Title</a>`) || strings.Contains(content.HTML, "Old Title") {
+		t.Errorf("html = %s", content.HTML)
+	}
+
+	// pages missing from the update's meta data don't resolve
+	resolve := pageResolver(DB, map[string]ContentMeta{"target": {ID: "target", RelPath: MISSING_FILE}, "new": {ID: "new", RelPath: "new.md"}})
+	if _, _, ok := resolve("target"); ok {
+		t.Error("missing page resolved")
+	}
+	if url, title, ok := resolve("new"); !ok || url != "/page?id=new" || title != "new" {
+		t.Errorf("resolve(new) = %s, %s, %v", url, title, ok)
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"sync/atomic"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+	"gorm.io/gorm"
+)
+
+const SCHEDULER_INTERVAL = time.Minute // how often scheduled pages are checked
+
+// Value type is map[string]bool, key: id of a page that isn't published
+var unpublishedCache = atomic.Value{}
+
+// isPublished returns true if a page with the given front matter should be public at the given time.
+func isPublished(front utils.FrontMatter, now time.Time) bool {
+	return !front.Draft && !front.Publish.After(now)
+}
+
+// IsPublished returns true if the page with the given id is public. Unknown pages are considered published.
+func IsPublished(id string) bool {
+	unpublished, _ := unpublishedCache.Load().(map[string]bool)
+	return !unpublished[id]
+}
+
+// loadUnpublishedCache fills the cache of pages that are drafts or scheduled.
+func loadUnpublishedCache() error {
+	var ids []string
+	if err := DB.Model(&ContentModel{}).Where("published = ?", false).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	unpublished := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		unpublished[id] = true
+	}
+	unpublishedCache.Store(unpublished)
+	return nil
+}
+
+// refreshPublished reloads everything that depends on which pages are published.
+func refreshPublished() error {
+	if err := loadUnpublishedCache(); err != nil {
+		return err
+	}
+	return refreshSitemap()
+}
+
+// PublishDue publishes scheduled pages whose publish time has passed. Returns the number of pages published.
+// Their updated time is set to now so they show up in feeds, and pages linking to them are rendered again to resolve the links.
+func PublishDue() (int, error) {
+	UpdateMutex.Lock()
+	defer UpdateMutex.Unlock()
+	var contents []ContentModel
+	if err := DB.Select("id", "front_draft", "front_publish").Where("published = ? AND front_draft = ?", false, false).Find(&contents).Error; err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var due []string
+	dueIDs := make(map[string]bool)
+	for _, content := range contents {
+		if isPublished(content.Front, now) {
+			due = append(due, content.ID)
+			dueIDs[content.ID] = true
+		}
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ContentModel{}).Where("id IN ?", due).Updates(map[string]interface{}{"published": true, "updated": now}).Error; err != nil {
+			return err
+		}
+		return rerenderLinking(tx, dueIDs, pageResolver(tx, nil))
+	})
+	if err != nil {
+		return 0, err
+	}
+	blog.Infof("Published %d scheduled pages: %v", len(due), due)
+	return len(due), refreshPublished()
+}
+
+// StartScheduler periodically publishes scheduled pages in the background.
+func StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(SCHEDULER_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := PublishDue(); err != nil {
+				blog.Errorf("Error publishing scheduled pages: %v", err)
+			}
+		}
+	}()
+}
+
+// GetPublicLayout returns the layout without links to unpublished pages. Folders left empty are removed.
+func GetPublicLayout() Layout {
+	layout := GetLayout()
+	layout.Sidebar = publicSidebarItems(layout.Sidebar)
+	footer := make([]FooterItem, 0, len(layout.Footer))
+	for _, item := range layout.Footer {
+		if item.Type == "footer-file" && !IsPublished(item.Meta.ID) {
+			continue
+		}
+		footer = append(footer, item)
+	}
+	layout.Footer = footer
+	return layout
+}
+
+func publicSidebarItems(items []SidebarItem) []SidebarItem {
+	result := make([]SidebarItem, 0, len(items))
+	for _, item := range items {
+		switch item.Type {
+		case "file":
+			if !IsPublished(item.Meta.ID) {
+				continue
+			}
+		case "folder":
+			contents := publicSidebarItems(item.Contents)
+			if len(contents) == 0 && len(item.Contents) != 0 {
+				continue
+			}
+			item.Contents = contents
+		}
+		result = append(result, item)
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPublished(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		front utils.FrontMatter
+		want  bool
+	}{
+		{utils.FrontMatter{}, true},
+		{utils.FrontMatter{Draft: true}, false},
+		{utils.FrontMatter{Publish: now.Add(-time.Minute)}, true},
+		{utils.FrontMatter{Publish: now}, true},
+		{utils.FrontMatter{Publish: now.Add(time.Minute)}, false},
+		{utils.FrontMatter{Draft: true, Publish: now.Add(-time.Minute)}, false},
+	}
+	for _, test := range tests {
+		if got := isPublished(test.front, now); got != test.want {
+			t.Errorf("isPublished(%+v) = %v, want %v", test.front, got, test.want)
+		}
+	}
+}
+
+func TestPublishDue(t *testing.T) {
+	setupTestDB(t)
+	later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	addTestPage(t, "public", "public.md", "<!-- ID: public -->\n# Public\n")
+	addTestPage(t, "draft", "draft.md", "<!-- ID: draft -->\n---\ndraft: true\ntitle: Secret Draft\n---\n")
+	scheduled := addTestPage(t, "soon", "soon.md", "<!-- ID: soon -->\n---\npublish: "+later+"\ntitle: Big News\n---\n")
+	linking := addTestPage(t, "linking", "linking.md", "<!-- ID: linking -->\n[[public]] [[draft]] [[soon]]\n")
+
+	// hidden pages don't leak their titles or urls into public html
+	if !strings.Contains(linking.HTML, `<a href="/p/public">public</a>`) {
+		t.Errorf("link to a public page not resolved: %s", linking.HTML)
+	}
+	for _, leak := range []string{"Secret Draft", "Big News", "/p/draft", "/p/soon"} {
+		if strings.Contains(linking.HTML, leak) {
+			t.Errorf("html links to a hidden page: %s", linking.HTML)
+		}
+	}
+	if IsPublished("soon") || IsPublished("draft") || !IsPublished("public") || !IsPublished("unknown") {
+		t.Error("unexpected published pages")
+	}
+	if n, err := PublishDue(); err != nil || n != 0 {
+		t.Fatalf("PublishDue() = %d, %v", n, err)
+	}
+
+	// once the publish time passes the page is published, bumped in the feeds, and linked
+	if err := DB.Model(&ContentModel{}).Where("id = ?", "soon").Update("front_publish", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := PublishDue(); err != nil || n != 1 {
+		t.Fatalf("PublishDue() = %d, %v", n, err)
+	}
+	if !IsPublished("soon") || IsPublished("draft") {
+		t.Error("unexpected published pages")
+	}
+	content, err := GetContent("soon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !content.Updated.After(scheduled.Updated) {
+		t.Errorf("updated time not bumped: %v", content.Updated)
+	}
+	recent, err := GetRecentContent(1)
+	if err != nil || len(recent) != 1 || recent[0].ID != "soon" {
+		t.Errorf("GetRecentContent() = %+v, %v", recent, err)
+	}
+	if content, err = GetContent("linking"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content.HTML, `<a href="/p/soon">Big News</a>`) || strings.Contains(content.HTML, "Secret Draft") {
+		t.Errorf("linking page not re-rendered: %s", content.HTML)
+	}
+}
+
+func TestGetPublicLayout(t *testing.T) {
+	setupTestDB(t)
+	addTestPage(t, "public", "public.md", "<!-- ID: public -->\n")
+	addTestPage(t, "draft", "draft.md", "<!-- ID: draft -->\n---\ndraft: true\n---\n")
+	layout := Layout{
+		Sidebar: []SidebarItem{
+			{Name: "Hidden", Type: "folder", Contents: []SidebarItem{{Name: "Draft", Type: "file", Meta: ContentMeta{ID: "draft"}}}},
+			{Name: "Empty", Type: "folder", Contents: []SidebarItem{}},
+			{Name: "Mixed", Type: "folder", Contents: []SidebarItem{
+				{Name: "Draft", Type: "file", Meta: ContentMeta{ID: "draft"}},
+				{Name: "Public", Type: "file", Meta: ContentMeta{ID: "public"}},
+			}},
+		},
+		Footer: []FooterItem{{Name: "Draft", Type: "footer-file", Meta: ContentMeta{ID: "draft"}}, {Name: "Text", Type: "footer-text"}},
+	}
+	if err := SetLayout(&layout, "test"); err != nil {
+		t.Fatal(err)
+	}
+	public := GetPublicLayout()
+	if len(public.Sidebar) != 2 || public.Sidebar[0].Name != "Empty" || len(public.Sidebar[1].Contents) != 1 || public.Sidebar[1].Contents[0].Name != "Public" {
+		t.Errorf("sidebar = %+v", public.Sidebar)
+	}
+	if len(public.Footer) != 1 || public.Footer[0].Name != "Text" {
+		t.Errorf("footer = %+v", public.Footer)
+	}
+	if len(GetLayout().Sidebar[0].Contents) != 1 {
+		t.Error("GetPublicLayout changed the cached layout")
+	}
+}
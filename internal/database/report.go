@@ -0,0 +1,246 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"intermark/internal/files"
+	"intermark/internal/utils"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+	"gorm.io/gorm"
+)
+
+const MAX_UPDATE_REPORTS = 20 // older reports are deleted
+
+// levels of id problems
+const (
+	ID_ERROR   = "error"   // a page couldn't be loaded
+	ID_WARNING = "warning" // loaded anyway, or a file that isn't a page yet
+)
+
+// UpdateReport lists the problems found during an update.
+type UpdateReport struct {
+	Started       time.Time         `json:"Started"`
+	Finished      time.Time         `json:"Finished"`
+	Commit        string            `json:"Commit"`
+	Pin           string            `json:"Pin"`     // commit hash or tag the site was pinned to, if any
+	Sources       map[string]string `json:"Sources"` // commits of the additional content sources, by name
+	Error         string            `json:"Error"`   // empty if the update succeeded
+	MissingFiles  []MissingFile     `json:"MissingFiles"`
+	IDProblems    []IDProblem       `json:"IDProblems"`
+	OrphanedItems []OrphanedItem    `json:"OrphanedItems"`
+	BrokenLinks   []BrokenLink      `json:"BrokenLinks"`
+	MissingAssets []BrokenLink      `json:"MissingAssets"`
+	FrontMatter   []FrontMatterErr  `json:"FrontMatter"`
+}
+
+// MissingFile is an id in ids.json without a matching file.
+type MissingFile struct {
+	ID      string `json:"ID"`
+	RelPath string `json:"RelPath"` // path from ids.json
+	Reason  string `json:"Reason"`
+}
+
+// IDProblem is a markdown file whose ID comment doesn't match ids.json, is shared with another file, or is missing.
+type IDProblem struct {
+	Level   string `json:"Level"` // ID_ERROR or ID_WARNING
+	ID      string `json:"ID"`    // empty if the file has no ID
+	RelPath string `json:"RelPath"`
+	Problem string `json:"Problem"`
+}
+
+// OrphanedItem is a layout item pointing at an id that no longer exists.
+type OrphanedItem struct {
+	Location string `json:"Location"` // e.g. "Sidebar/Guides/Setup", "Footer/Contact", or "Landing"
+	ID       string `json:"ID"`
+}
+
+// BrokenLink is a link in a page to a page id or asset that doesn't exist.
+type BrokenLink struct {
+	PageID  string `json:"PageID"`
+	RelPath string `json:"RelPath"`
+	Target  string `json:"Target"` // page id or asset url
+}
+
+// FrontMatterErr is a page whose front matter couldn't be parsed, it's rendered with the block left in.
+type FrontMatterErr struct {
+	ID      string `json:"ID"`
+	RelPath string `json:"RelPath"`
+	Error   string `json:"Error"`
+}
+
+type UpdateReportModel struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Report    string // marshalled UpdateReport
+}
+
+// HasProblems returns true if the update failed or found anything worth looking at.
+func (r *UpdateReport) HasProblems() bool {
+	return r.Error != "" || len(r.MissingFiles) != 0 || len(r.IDProblems) != 0 || len(r.OrphanedItems) != 0 || len(r.BrokenLinks) != 0 || len(r.MissingAssets) != 0 || len(r.FrontMatter) != 0
+}
+
+func (r *UpdateReport) addMissingFile(id, relPath, reason string) {
+	if r != nil {
+		r.MissingFiles = append(r.MissingFiles, MissingFile{ID: id, RelPath: relPath, Reason: reason})
+	}
+}
+
+func (r *UpdateReport) addIDProblem(level, id, relPath, problem string) {
+	if r != nil {
+		r.IDProblems = append(r.IDProblems, IDProblem{Level: level, ID: id, RelPath: relPath, Problem: problem})
+	}
+}
+
+func (r *UpdateReport) addOrphanedItem(location, id string) {
+	if r != nil {
+		r.OrphanedItems = append(r.OrphanedItems, OrphanedItem{Location: location, ID: id})
+	}
+}
+
+// GetLatestUpdateReport retrieves the report of the most recent update, or nil if there hasn't been one.
+func GetLatestUpdateReport() (*UpdateReport, error) {
+	var model UpdateReportModel
+	if err := DB.Order("id DESC").First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var report UpdateReport
+	if err := json.Unmarshal([]byte(model.Report), &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// saveUpdateReport stores the report and deletes old ones.
+func saveUpdateReport(report *UpdateReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	model := UpdateReportModel{Report: string(data)}
+	if err := DB.Create(&model).Error; err != nil {
+		return err
+	}
+	if model.ID > MAX_UPDATE_REPORTS {
+		return DB.Where("id <= ?", model.ID-MAX_UPDATE_REPORTS).Delete(&UpdateReportModel{}).Error
+	}
+	return nil
+}
+
+// checkContentLinks adds links to unknown page ids and missing assets in any page to the report.
+// Assets of every content source are looked up in assetsPath, e.g. "data/assets".
+func checkContentLinks(db *gorm.DB, report *UpdateReport, metaDataMap map[string]ContentMeta, assetsPath string) error {
+	var prefixes []string
+	for _, source := range utils.Config.ContentSources() {
+		prefixes = append(prefixes, regexp.QuoteMeta(path.Join(source.Mount, source.AssetsDir)))
+	}
+	assetLinkRegex := regexp.MustCompile(`(?:src|href)="/(` + strings.Join(prefixes, "|") + `)/([^"#?]+)`)
+	var contents []ContentModel
+	if err := db.Select("id", "html", "links").Find(&contents).Error; err != nil {
+		return err
+	}
+	for _, content := range contents {
+		meta, ok := metaDataMap[content.ID]
+		if !ok || meta.RelPath == MISSING_FILE {
+			continue
+		}
+		for _, id := range content.Links {
+			if target, ok := metaDataMap[id]; !ok || target.RelPath == MISSING_FILE {
+				report.BrokenLinks = append(report.BrokenLinks, BrokenLink{PageID: meta.ID, RelPath: meta.RelPath, Target: id})
+			}
+		}
+		seen := make(map[string]bool)
+		for _, match := range assetLinkRegex.FindAllStringSubmatch(content.HTML, -1) {
+			assetPath, err := url.PathUnescape(match[2])
+			if err != nil || seen[match[1]+"/"+assetPath] {
+				continue
+			}
+			seen[match[1]+"/"+assetPath] = true
+			local := filepath.Join(assetsPath, filepath.FromSlash(match[1]), filepath.FromSlash(path.Clean("/"+assetPath)))
+			if exists, err := files.Exists(local); err != nil {
+				return err
+			} else if !exists {
+				target := "/" + match[1] + "/" + strings.TrimPrefix(assetPath, "/")
+				report.MissingAssets = append(report.MissingAssets, BrokenLink{PageID: meta.ID, RelPath: meta.RelPath, Target: target})
+			}
+		}
+	}
+	if len(report.BrokenLinks) != 0 || len(report.MissingAssets) != 0 {
+		blog.Warnf("Found %d broken links and %d missing assets", len(report.BrokenLinks), len(report.MissingAssets))
+	}
+	return nil
+}
+
+// checkFrontMatter adds the pages whose front matter couldn't be parsed to the report.
+func checkFrontMatter(db *gorm.DB, report *UpdateReport, metaDataMap map[string]ContentMeta) error {
+	var contents []ContentModel
+	if err := db.Select("id", "front_err").Where("front_err <> ?", "").Order("id").Find(&contents).Error; err != nil {
+		return err
+	}
+	for _, content := range contents {
+		if meta, ok := metaDataMap[content.ID]; ok && meta.RelPath != MISSING_FILE {
+			report.FrontMatter = append(report.FrontMatter, FrontMatterErr{ID: meta.ID, RelPath: meta.RelPath, Error: content.FrontErr})
+		}
+	}
+	return nil
+}
+
+// notifyUpdateReport sends webhook notifications for the outcome of an update and the problems it found.
+func notifyUpdateReport(report *UpdateReport) {
+	if report.Error != "" {
+		utils.NotifyWebhooks(utils.EVENT_UPDATE_FAILURE, "Content update failed: "+report.Error, nil)
+		return
+	}
+	utils.NotifyWebhooks(utils.EVENT_UPDATE_SUCCESS, fmt.Sprintf("Content updated to commit %s", report.Commit), nil)
+	if len(report.MissingFiles) != 0 {
+		details := make([]string, 0, len(report.MissingFiles))
+		for _, missing := range report.MissingFiles {
+			details = append(details, fmt.Sprintf("%s (%s): %s", missing.RelPath, missing.ID, missing.Reason))
+		}
+		utils.NotifyWebhooks(utils.EVENT_MISSING_FILES, fmt.Sprintf("%d ids in ids.json have no matching file", len(details)), details)
+	}
+	if len(report.IDProblems) != 0 {
+		details := make([]string, 0, len(report.IDProblems))
+		errorCount := 0
+		for _, problem := range report.IDProblems {
+			details = append(details, fmt.Sprintf("%s: %s (%s): %s", problem.Level, problem.RelPath, problem.ID, problem.Problem))
+			if problem.Level == ID_ERROR {
+				errorCount++
+			}
+		}
+		utils.NotifyWebhooks(utils.EVENT_ID_PROBLEMS, fmt.Sprintf("%d files have ID errors and %d have warnings", errorCount, len(details)-errorCount), details)
+	}
+	if len(report.OrphanedItems) != 0 {
+		details := make([]string, 0, len(report.OrphanedItems))
+		for _, item := range report.OrphanedItems {
+			details = append(details, fmt.Sprintf("%s -> %s", item.Location, item.ID))
+		}
+		utils.NotifyWebhooks(utils.EVENT_ORPHANED_ITEMS, fmt.Sprintf("%d layout items point at pages that no longer exist", len(details)), details)
+	}
+	if len(report.BrokenLinks) != 0 || len(report.MissingAssets) != 0 {
+		details := make([]string, 0, len(report.BrokenLinks)+len(report.MissingAssets))
+		for _, links := range [][]BrokenLink{report.BrokenLinks, report.MissingAssets} {
+			for _, link := range links {
+				details = append(details, fmt.Sprintf("%s -> %s", link.RelPath, link.Target))
+			}
+		}
+		utils.NotifyWebhooks(utils.EVENT_BROKEN_LINKS, fmt.Sprintf("%d links point at pages or assets that don't exist", len(details)), details)
+	}
+	if len(report.FrontMatter) != 0 {
+		details := make([]string, 0, len(report.FrontMatter))
+		for _, page := range report.FrontMatter {
+			details = append(details, fmt.Sprintf("%s (%s): %s", page.RelPath, page.ID, page.Error))
+		}
+		utils.NotifyWebhooks(utils.EVENT_FRONT_MATTER, fmt.Sprintf("%d pages have invalid front matter", len(details)), details)
+	}
+}
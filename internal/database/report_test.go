@@ -0,0 +1,157 @@
+package database
+
+import (
+	"intermark/internal/files"
+	"intermark/internal/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCheckContentLinks(t *testing.T) {
+	setupTestDB(t)
+	utils.Config.Sources = []utils.ContentSource{{Name: "blog", URL: "x", Mount: "blog", AssetsDir: "media"}}
+	addTestPage(t, "aaa", "a.md", "<!-- ID: aaa -->\n[[bbb]] [[gone]] [[ccc]]\n![](/assets/logo.png) ![](/assets/missing%20file.png) ![](/assets/missing%20file.png#x)\n")
+	addTestPage(t, "bbb", "b.md", "<!-- ID: bbb -->\n[x](/blog/media/pic.png) [y](/blog/media/nope.png) [z](/other/file.png)\n")
+	addTestPage(t, "ccc", "c.md", "<!-- ID: ccc -->\n[[aaa]]\n")
+
+	assetsPath := t.TempDir()
+	for _, path := range []string{"assets/logo.png", "blog/media/pic.png"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(assetsPath, path)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := files.CreateFile(filepath.Join(assetsPath, path), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// ccc is missing, so links to it are broken and its own links aren't checked
+	metaDataMap := map[string]ContentMeta{
+		"aaa": {ID: "aaa", RelPath: "a.md"},
+		"bbb": {ID: "bbb", RelPath: "b.md"},
+		"ccc": {ID: "ccc", RelPath: MISSING_FILE},
+	}
+	report := &UpdateReport{}
+	if err := checkContentLinks(DB, report, metaDataMap, assetsPath); err != nil {
+		t.Fatal(err)
+	}
+	wantLinks := []BrokenLink{{PageID: "aaa", RelPath: "a.md", Target: "gone"}, {PageID: "aaa", RelPath: "a.md", Target: "ccc"}}
+	if !reflect.DeepEqual(report.BrokenLinks, wantLinks) {
+		t.Errorf("broken links = %+v", report.BrokenLinks)
+	}
+	wantAssets := []BrokenLink{{PageID: "aaa", RelPath: "a.md", Target: "/assets/missing file.png"}, {PageID: "bbb", RelPath: "b.md", Target: "/blog/media/nope.png"}}
+	if !reflect.DeepEqual(report.MissingAssets, wantAssets) {
+		t.Errorf("missing assets = %+v", report.MissingAssets)
+	}
+	if !report.HasProblems() || (&UpdateReport{Commit: "abc"}).HasProblems() {
+		t.Error("unexpected HasProblems()")
+	}
+}
+
+func TestOrphanedItems(t *testing.T) {
+	metaDataMap := map[string]ContentMeta{"aaa": {ID: "aaa", RelPath: "new/a.md", Commit: "c2"}}
+	sidebar := []SidebarItem{
+		{Name: "Guides", Type: "folder", Contents: []SidebarItem{
+			{Name: "A", Type: "file", Meta: ContentMeta{ID: "aaa", RelPath: "a.md", Commit: "c1"}},
+			{Name: "Gone", Type: "file", Meta: ContentMeta{ID: "gone"}},
+			{Name: "Unset", Type: "file"},
+		}},
+	}
+	footer := []FooterItem{{Name: "Old", Type: "footer-file", Meta: ContentMeta{ID: "old"}}, {Name: "Link", Type: "footer-link"}}
+	report := &UpdateReport{}
+	updateSidebarItems(sidebar, metaDataMap, "Sidebar", report)
+	updateFooterItems(footer, metaDataMap, report)
+	if sidebar[0].Contents[0].Meta != metaDataMap["aaa"] {
+		t.Errorf("meta data not updated: %+v", sidebar[0].Contents[0].Meta)
+	}
+	want := []OrphanedItem{{Location: "Sidebar/Guides/Gone", ID: "gone"}, {Location: "Footer/Old", ID: "old"}}
+	if !reflect.DeepEqual(report.OrphanedItems, want) {
+		t.Errorf("orphaned items = %+v", report.OrphanedItems)
+	}
+	// a nil report is allowed
+	updateSidebarItems(sidebar, nil, "Sidebar", nil)
+}
+
+func TestUpdateReports(t *testing.T) {
+	setupTestDB(t)
+	if report, err := GetLatestUpdateReport(); err != nil || report != nil {
+		t.Fatalf("GetLatestUpdateReport() = %+v, %v", report, err)
+	}
+	for i := 0; i < MAX_UPDATE_REPORTS+5; i++ {
+		report := &UpdateReport{Commit: string(rune('a' + i))}
+		report.addMissingFile("aaa", "a.md", "file not found")
+		if err := saveUpdateReport(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int64
+	if err := DB.Model(&UpdateReportModel{}).Count(&count).Error; err != nil || count != MAX_UPDATE_REPORTS {
+		t.Errorf("%d reports kept, %v", count, err)
+	}
+	report, err := GetLatestUpdateReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Commit != string(rune('a'+MAX_UPDATE_REPORTS+4)) || len(report.MissingFiles) != 1 || report.MissingFiles[0].Reason != "file not found" {
+		t.Errorf("latest report = %+v", report)
+	}
+}
+
+func TestAddMissingReason(t *testing.T) {
+	dir := t.TempDir()
+	for name, md := range map[string]string{"other.md": "<!-- ID: other -->\n", "noid.md": "# No ID\n"} {
+		if err := files.CreateFile(filepath.Join(dir, name), md); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := &UpdateReport{}
+	for _, relPath := range []string{"gone.md", "other.md", "noid.md"} {
+		if err := addMissingReason(dir, "blog", "aaa", relPath, report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []MissingFile{
+		{ID: "blog:aaa", RelPath: "gone.md", Reason: "file not found"},
+		{ID: "blog:aaa", RelPath: "other.md", Reason: "file has ID 'other'"},
+		{ID: "blog:aaa", RelPath: "noid.md", Reason: "first line isn't a valid ID comment"},
+	}
+	if !reflect.DeepEqual(report.MissingFiles, want) {
+		t.Errorf("missing files = %+v", report.MissingFiles)
+	}
+}
+
+func TestLoadIDsReport(t *testing.T) {
+	dir := t.TempDir()
+	ids := map[string]string{"aaa": "a.md", "gone": "gone.md", "other": "other.md", "noid": "noid.md"}
+	if err := os.MkdirAll(filepath.Join(dir, ".github"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := files.SaveJSON(filepath.Join(dir, ".github", "ids.json"), ids, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, md := range map[string]string{"a.md": "<!-- ID: aaa -->\n", "other.md": "<!-- ID: aaa -->\n", "noid.md": "# No ID\n"} {
+		if err := files.CreateFile(filepath.Join(dir, name), md); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := &UpdateReport{}
+	metaDatas, err := loadIDs(dir, "", report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metaData := range metaDatas {
+		if (metaData.RelPath == MISSING_FILE) == (metaData.ID == "aaa") {
+			t.Errorf("unexpected meta data %+v", metaData)
+		}
+	}
+	sort.Slice(report.MissingFiles, func(i, j int) bool { return report.MissingFiles[i].ID < report.MissingFiles[j].ID })
+	want := []MissingFile{
+		{ID: "gone", RelPath: "gone.md", Reason: "file not found"},
+		{ID: "noid", RelPath: "noid.md", Reason: "first line isn't a valid ID comment"},
+		{ID: "other", RelPath: "other.md", Reason: "file has ID 'aaa'"},
+	}
+	if !reflect.DeepEqual(report.MissingFiles, want) {
+		t.Errorf("missing files = %+v", report.MissingFiles)
+	}
+}
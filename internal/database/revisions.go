@@ -0,0 +1,295 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+	"gorm.io/gorm"
+)
+
+const MAX_LAYOUT_REVISIONS = 20 // older revisions are deleted
+
+// LayoutRevisionModel is a snapshot of the layout, one is created each time it's saved.
+type LayoutRevisionModel struct {
+	ID        uint      `json:"ID" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	Author    string    `json:"Author"`
+	Sidebar   string    `json:"-"`
+	Footer    string    `json:"-"`
+	Landing   string    `json:"-"`
+}
+
+// LayoutChange describes a single difference between two layouts.
+type LayoutChange struct {
+	Kind   string `json:"Kind"` // "added", "removed", "moved", "renamed", "changed", or "reordered"
+	Path   string `json:"Path"` // location of the item, e.g. "Sidebar/Guides/Setup"
+	Before string `json:"Before"`
+	After  string `json:"After"`
+}
+
+// layoutEntry is a flattened layout item used for diffing.
+type layoutEntry struct {
+	key    string // stable identity of the item
+	parent string
+	name   string
+	value  string // compared to detect changes
+	detail string // shown to the user
+}
+
+// initLayoutRevisions records the current layout as the first revision for databases created before revisions existed.
+func initLayoutRevisions() error {
+	var count int64
+	if err := DB.Model(&LayoutRevisionModel{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count != 0 {
+		return nil
+	}
+	var layout LayoutModel
+	if err := DB.First(&layout).Error; err != nil {
+		return err
+	}
+	return DB.Create(&LayoutRevisionModel{CreatedAt: layout.UpdatedAt, Author: layout.Author, Sidebar: layout.Sidebar, Footer: layout.Footer, Landing: layout.Landing}).Error
+}
+
+// saveLayoutRevision records the layout as a new revision, then deletes the oldest ones past MAX_LAYOUT_REVISIONS.
+// It's skipped if the layout is the same as the last revision apart from page meta data, which updates change as files move.
+func saveLayoutRevision(tx *gorm.DB, layout *Layout, author string) error {
+	var latest LayoutRevisionModel
+	if err := tx.Order("id DESC").Limit(1).Find(&latest).Error; err != nil {
+		return err
+	}
+	if latest.ID != 0 {
+		previous, err := unmarshalLayout(latest.Sidebar, latest.Footer, latest.Landing)
+		if err != nil {
+			return err
+		}
+		if len(DiffLayouts(previous, layout)) == 0 {
+			return nil
+		}
+	}
+	mS, mF, mL, err := marshalLayout(layout)
+	if err != nil {
+		return err
+	}
+	revision := LayoutRevisionModel{Author: author, Sidebar: mS, Footer: mF, Landing: mL}
+	if err := tx.Create(&revision).Error; err != nil {
+		return err
+	}
+	if revision.ID > MAX_LAYOUT_REVISIONS {
+		return tx.Where("id <= ?", revision.ID-MAX_LAYOUT_REVISIONS).Delete(&LayoutRevisionModel{}).Error
+	}
+	return nil
+}
+
+// GetLayoutRevisions retrieves the most recent layout revisions, newest first.
+func GetLayoutRevisions() ([]LayoutRevisionModel, error) {
+	var revisions []LayoutRevisionModel
+	if err := DB.Select("id", "created_at", "author").Order("id DESC").Limit(MAX_LAYOUT_REVISIONS).Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetLayoutRevision retrieves the layout saved in the revision with the given id.
+func GetLayoutRevision(id uint) (*Layout, error) {
+	var revision LayoutRevisionModel
+	if err := DB.First(&revision, id).Error; err != nil {
+		return nil, err
+	}
+	return unmarshalLayout(revision.Sidebar, revision.Footer, revision.Landing)
+}
+
+// RestoreLayoutRevision makes the layout from the given revision the current one, recorded as a new revision.
+// Page meta data is refreshed so restored items point at where the content is now. Caller should hold UpdateMutex.
+func RestoreLayoutRevision(id uint, author string) (*Layout, error) {
+	layout, err := GetLayoutRevision(id)
+	if err != nil {
+		return nil, err
+	}
+	metaDatas, err := GetMeta()
+	if err != nil {
+		return nil, err
+	}
+	metaDataMap := make(map[string]ContentMeta, len(metaDatas))
+	for _, metaData := range metaDatas {
+		metaDataMap[metaData.ID] = metaData
+	}
+	updateSidebarItems(layout.Sidebar, metaDataMap, "Sidebar", nil)
+	updateFooterItems(layout.Footer, metaDataMap, nil)
+	if meta, ok := metaDataMap[layout.Landing.ID]; ok {
+		layout.Landing = meta
+	}
+	if err := SetLayout(layout, author); err != nil {
+		return nil, err
+	}
+	blog.Infof("Layout revision %d restored by '%s'", id, author)
+	return layout, nil
+}
+
+// DiffLayouts returns the structural changes needed to go from layout a to layout b.
+func DiffLayouts(a, b *Layout) []LayoutChange {
+	changes := []LayoutChange{}
+	if a.Landing.ID != b.Landing.ID {
+		changes = append(changes, LayoutChange{Kind: "changed", Path: "Landing", Before: describeMeta(a.Landing), After: describeMeta(b.Landing)})
+	}
+	changes = append(changes, diffEntries(flattenLayout(a), flattenLayout(b))...)
+	return changes
+}
+
+func diffEntries(a, b []layoutEntry) []LayoutChange {
+	var changes []LayoutChange
+	aMap := make(map[string]layoutEntry, len(a))
+	for _, entry := range a {
+		aMap[entry.key] = entry
+	}
+	bMap := make(map[string]layoutEntry, len(b))
+	for _, entry := range b {
+		bMap[entry.key] = entry
+	}
+	for _, entry := range a {
+		if _, ok := bMap[entry.key]; !ok {
+			changes = append(changes, LayoutChange{Kind: "removed", Path: entry.parent + "/" + entry.name, Before: entry.detail})
+		}
+	}
+	for _, after := range b {
+		before, ok := aMap[after.key]
+		path := after.parent + "/" + after.name
+		switch {
+		case !ok:
+			changes = append(changes, LayoutChange{Kind: "added", Path: path, After: after.detail})
+		case before.parent != after.parent:
+			changes = append(changes, LayoutChange{Kind: "moved", Path: path, Before: before.parent, After: after.parent})
+		case before.name != after.name:
+			changes = append(changes, LayoutChange{Kind: "renamed", Path: path, Before: before.name, After: after.name})
+		case before.value != after.value:
+			changes = append(changes, LayoutChange{Kind: "changed", Path: path, Before: before.detail, After: after.detail})
+		}
+	}
+	// check if items that stayed in the same parent changed order
+	aOrder, bOrder := parentOrder(a, bMap), parentOrder(b, aMap)
+	for _, entry := range b {
+		parent := entry.parent
+		if bOrder[parent] == nil {
+			continue // already reported
+		}
+		if strings.Join(aOrder[parent], "\n") != strings.Join(bOrder[parent], "\n") {
+			changes = append(changes, LayoutChange{Kind: "reordered", Path: parent})
+		}
+		bOrder[parent] = nil
+	}
+	return changes
+}
+
+// parentOrder groups the keys of entries that also exist in other, and haven't moved, by parent.
+func parentOrder(entries []layoutEntry, other map[string]layoutEntry) map[string][]string {
+	order := make(map[string][]string)
+	for _, entry := range entries {
+		if o, ok := other[entry.key]; ok && o.parent == entry.parent {
+			order[entry.parent] = append(order[entry.parent], entry.key)
+		}
+	}
+	return order
+}
+
+func flattenLayout(layout *Layout) []layoutEntry {
+	entries := flattenSidebar(layout.Sidebar, "Sidebar", nil)
+	for i, item := range layout.Footer {
+		entry := layoutEntry{parent: "Footer", name: item.Name}
+		switch item.Type {
+		case "footer-file":
+			entry.key = "footer-file:" + firstNonEmpty(item.Meta.ID, item.Name)
+			entry.value, entry.detail = item.Meta.ID, describeMeta(item.Meta)
+		case "footer-link":
+			entry.key = "footer-link:" + item.Name
+			entry.value, entry.detail = item.Link, item.Link
+		default:
+			entry.key = fmt.Sprintf("%s:%d:%s", item.Type, i, item.Name)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func flattenSidebar(items []SidebarItem, parent string, entries []layoutEntry) []layoutEntry {
+	for i, item := range items {
+		entry := layoutEntry{parent: parent, name: item.Name}
+		switch item.Type {
+		case "file":
+			entry.key = "file:" + firstNonEmpty(item.Meta.ID, parent+"/"+item.Name)
+			entry.value, entry.detail = item.Meta.ID, describeMeta(item.Meta)
+		case "folder":
+			entry.key = "folder:" + parent + "/" + item.Name
+		default:
+			entry.key = fmt.Sprintf("%s:%s:%d", item.Type, parent, i)
+			entry.name = fmt.Sprintf("%s %d", item.Type, i+1)
+		}
+		entries = append(entries, entry)
+		if item.Type == "folder" {
+			entries = flattenSidebar(item.Contents, parent+"/"+item.Name, entries)
+		}
+	}
+	return entries
+}
+
+func describeMeta(meta ContentMeta) string {
+	if meta.ID == "" {
+		return "none"
+	}
+	return meta.RelPath + " (" + meta.ID + ")"
+}
+
+func firstNonEmpty(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// marshalLayout returns the JSON of the sidebar, footer, and landing.
+func marshalLayout(layout *Layout) (string, string, string, error) {
+	mS, err := json.Marshal(layout.Sidebar)
+	if err != nil {
+		return "", "", "", err
+	}
+	mF, err := json.Marshal(layout.Footer)
+	if err != nil {
+		return "", "", "", err
+	}
+	mL, err := json.Marshal(layout.Landing)
+	if err != nil {
+		return "", "", "", err
+	}
+	return string(mS), string(mF), string(mL), nil
+}
+
+// unmarshalLayout is the inverse of marshalLayout.
+func unmarshalLayout(sidebar, footer, landing string) (*Layout, error) {
+	var result Layout
+	if err := json.Unmarshal([]byte(sidebar), &result.Sidebar); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(footer), &result.Footer); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(landing), &result.Landing); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// cloneLayout returns a deep copy of the layout.
+func cloneLayout(layout *Layout) (Layout, error) {
+	mS, mF, mL, err := marshalLayout(layout)
+	if err != nil {
+		return Layout{}, err
+	}
+	clone, err := unmarshalLayout(mS, mF, mL)
+	if err != nil {
+		return Layout{}, err
+	}
+	return *clone, nil
+}
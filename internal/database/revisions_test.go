@@ -0,0 +1,121 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffLayouts(t *testing.T) {
+	setup := ContentMeta{ID: "aaa", RelPath: "setup.md"}
+	before := &Layout{
+		Sidebar: []SidebarItem{
+			{Name: "Guides", Type: "folder", Contents: []SidebarItem{
+				{Name: "Setup", Type: "file", Meta: setup},
+				{Name: "Usage", Type: "file", Meta: ContentMeta{ID: "bbb", RelPath: "usage.md"}},
+			}},
+			{Name: "FAQ", Type: "file", Meta: ContentMeta{ID: "ccc", RelPath: "faq.md"}},
+			{Name: "Old", Type: "file", Meta: ContentMeta{ID: "ddd", RelPath: "old.md"}},
+		},
+		Footer: []FooterItem{{Name: "Home", Type: "footer-link", Link: "https://a.example"}},
+	}
+	after := &Layout{
+		Sidebar: []SidebarItem{
+			{Name: "Guides", Type: "folder", Contents: []SidebarItem{
+				{Name: "Usage", Type: "file", Meta: ContentMeta{ID: "bbb", RelPath: "usage.md"}},
+				{Name: "Getting Started", Type: "file", Meta: setup},
+				{Name: "FAQ", Type: "file", Meta: ContentMeta{ID: "ccc", RelPath: "faq.md"}},
+			}},
+			{Name: "New", Type: "file", Meta: ContentMeta{ID: "eee", RelPath: "new.md"}},
+		},
+		Footer:  []FooterItem{{Name: "Home", Type: "footer-link", Link: "https://b.example"}},
+		Landing: setup,
+	}
+
+	want := []LayoutChange{
+		{Kind: "changed", Path: "Landing", Before: "none", After: "setup.md (aaa)"},
+		{Kind: "removed", Path: "Sidebar/Old", Before: "old.md (ddd)"},
+		{Kind: "renamed", Path: "Sidebar/Guides/Getting Started", Before: "Setup", After: "Getting Started"},
+		{Kind: "moved", Path: "Sidebar/Guides/FAQ", Before: "Sidebar", After: "Sidebar/Guides"},
+		{Kind: "added", Path: "Sidebar/New", After: "new.md (eee)"},
+		{Kind: "changed", Path: "Footer/Home", Before: "https://a.example", After: "https://b.example"},
+		{Kind: "reordered", Path: "Sidebar/Guides"},
+	}
+	if got := DiffLayouts(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffLayouts() =\n%+v\nwant\n%+v", got, want)
+	}
+	if got := DiffLayouts(before, before); len(got) != 0 {
+		t.Errorf("DiffLayouts(same) = %+v", got)
+	}
+}
+
+func TestLayoutRevisions(t *testing.T) {
+	setupTestDB(t)
+	addTestPage(t, "aaa", "setup.md", "<!-- ID: aaa -->\n# Setup\n")
+	first := GetLayout()
+	second := Layout{Footer: first.Footer, Landing: first.Landing}
+	second.Sidebar = []SidebarItem{{Name: "Setup", Type: "file", Meta: ContentMeta{ID: "aaa", RelPath: "old/setup.md"}}}
+	if err := SetLayout(&second, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := GetLayoutRevisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Author != "alice" || revisions[1].Author != SYSTEM_AUTHOR {
+		t.Fatalf("revisions = %+v", revisions)
+	}
+
+	// restoring refreshes the page meta data, that alone isn't a new revision
+	restored, err := RestoreLayoutRevision(revisions[0].ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Sidebar[0].Meta.RelPath != "setup.md" || GetLayout().Sidebar[0].Meta.RelPath != "setup.md" {
+		t.Errorf("restored sidebar = %+v", restored.Sidebar)
+	}
+	if _, err := RestoreLayoutRevision(revisions[1].ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if len(GetLayout().Sidebar) != 0 {
+		t.Errorf("sidebar = %+v", GetLayout().Sidebar)
+	}
+	if revisions, err = GetLayoutRevisions(); err != nil || len(revisions) != 3 || revisions[0].Author != "bob" {
+		t.Errorf("revisions = %+v, %v", revisions, err)
+	}
+	if _, err := RestoreLayoutRevision(999, "bob"); err == nil {
+		t.Error("restored a missing revision")
+	}
+}
+
+func TestLayoutRevisionLimit(t *testing.T) {
+	setupTestDB(t)
+	layout := GetLayout()
+	for i := 0; i < MAX_LAYOUT_REVISIONS+5; i++ {
+		layout.Footer = []FooterItem{{Name: "Link", Type: "footer-link", Link: fmt.Sprintf("https://%d.example", i)}}
+		if err := SetLayout(&layout, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int64
+	if err := DB.Model(&LayoutRevisionModel{}).Count(&count).Error; err != nil || count != MAX_LAYOUT_REVISIONS {
+		t.Errorf("%d revisions kept, %v", count, err)
+	}
+
+	// page meta data changes, like an update moving a file, don't add revisions
+	layout.Sidebar = []SidebarItem{{Name: "Setup", Type: "file", Meta: ContentMeta{ID: "aaa", RelPath: "setup.md", Commit: "a"}}}
+	if err := SetLayout(&layout, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	layout.Sidebar[0].Meta = ContentMeta{ID: "aaa", RelPath: "guides/setup.md", Commit: "b"}
+	if err := SetLayout(&layout, SYSTEM_AUTHOR); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, err := GetLayoutRevisions(); err != nil || revisions[0].Author != "alice" {
+		t.Errorf("revisions = %+v, %v", revisions, err)
+	}
+	if GetLayout().Sidebar[0].Meta.RelPath != "guides/setup.md" {
+		t.Errorf("layout not saved: %+v", GetLayout().Sidebar)
+	}
+}
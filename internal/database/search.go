@@ -0,0 +1,116 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"intermark/internal/utils"
+	"regexp"
+	"strings"
+
+	"github.com/Data-Corruption/blog"
+	"gorm.io/gorm"
+)
+
+const MAX_SEARCH_RESULTS = 50
+
+// markers used to highlight matches in snippets, swapped for <mark> tags after escaping
+const (
+	snippetOpen  = "\x02"
+	snippetClose = "\x03"
+)
+
+var (
+	tagRegex   = regexp.MustCompile(`(?s)<script.*?</script>|<style.*?</style>|<!--.*?-->|<[^>]*>`)
+	spaceRegex = regexp.MustCompile(`\s+`)
+)
+
+type SearchResult struct {
+	ID      string `json:"ID"`
+	Title   string `json:"Title"` // see DisplayTitle
+	RelPath string `json:"RelPath"`
+	Snippet string `json:"Snippet"` // html safe, matches wrapped in <mark>
+	Link    string `json:"Link"`
+}
+
+// initSearch creates the full-text search index if needed and fills it if it's empty.
+func initSearch() error {
+	if err := DB.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS content_fts USING fts5(id UNINDEXED, rel_path, body)").Error; err != nil {
+		return err
+	}
+	var indexed, total int64
+	if err := DB.Raw("SELECT COUNT(*) FROM content_fts").Scan(&indexed).Error; err != nil {
+		return err
+	}
+	if err := DB.Model(&ContentModel{}).Count(&total).Error; err != nil {
+		return err
+	}
+	if indexed != 0 || total == 0 {
+		return nil
+	}
+	// backfill from existing content
+	blog.Infof("Building search index for %d pages", total)
+	var contents []ContentModel
+	if err := DB.Select("id", "rel_path", "html").Find(&contents).Error; err != nil {
+		return err
+	}
+	for i := range contents {
+		if err := indexContent(DB, &contents[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexContent adds or replaces the search entry for the given content.
+func indexContent(db *gorm.DB, content *ContentModel) error {
+	if err := db.Exec("DELETE FROM content_fts WHERE id = ?", content.ID).Error; err != nil {
+		return err
+	}
+	return db.Exec("INSERT INTO content_fts (id, rel_path, body) VALUES (?, ?, ?)", content.ID, content.RelPath, htmlToText(content.HTML)).Error
+}
+
+// cleanupSearch removes search entries for all ids not in the given list.
+func cleanupSearch(db *gorm.DB, ids []string) error {
+	return db.Exec("DELETE FROM content_fts WHERE id NOT IN ?", ids).Error
+}
+
+// Search runs a full-text search over all published page content, best matches first.
+func Search(query string, limit int) ([]SearchResult, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return []SearchResult{}, nil
+	}
+	if limit <= 0 || limit > MAX_SEARCH_RESULTS {
+		limit = MAX_SEARCH_RESULTS
+	}
+	var results []SearchResult
+	snippet := fmt.Sprintf("snippet(content_fts, 2, '%s', '%s', '…', 24)", snippetOpen, snippetClose)
+	err := DB.Raw("SELECT content_fts.id, content_models.rel_path, content_models.front_title AS title, "+snippet+" AS snippet "+
+		"FROM content_fts JOIN content_models ON content_models.id = content_fts.id "+
+		"WHERE content_fts MATCH ? AND content_models.published = ? ORDER BY bm25(content_fts) LIMIT ?", match, true, limit).Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		page := ContentModel{ContentMeta: ContentMeta{RelPath: results[i].RelPath}, Front: utils.FrontMatter{Title: results[i].Title}}
+		results[i].Title = page.DisplayTitle()
+		results[i].Snippet = strings.NewReplacer(snippetOpen, "<mark>", snippetClose, "</mark>").Replace(html.EscapeString(results[i].Snippet))
+		results[i].Link = PageURL(results[i].ID)
+	}
+	return results, nil
+}
+
+// ftsQuery turns user input into a safe FTS5 query, each term is quoted and prefix matched.
+func ftsQuery(query string) string {
+	var terms []string
+	for _, term := range strings.Fields(query) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// htmlToText strips tags, comments, scripts, and styles from the given html.
+func htmlToText(input string) string {
+	text := tagRegex.ReplaceAllString(input, " ")
+	return strings.TrimSpace(spaceRegex.ReplaceAllString(html.UnescapeString(text), " "))
+}
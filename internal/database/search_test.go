@@ -0,0 +1,62 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFtsQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", ""},
+		{"  ", ""},
+		{"install", `"install"*`},
+		{"getting started", `"getting"* "started"*`},
+		{`say "hi" OR`, `"say"* """hi"""* "OR"*`},
+	}
+	for _, test := range tests {
+		if got := ftsQuery(test.query); got != test.want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestHtmlToText(t *testing.T) {
+	input := "<h1>Title</h1>\n<script>var x = 1;</script><!-- note --><p>a &amp; <b>b</b></p><style>p{}</style>"
+	if got := htmlToText(input); got != "Title a & b" {
+		t.Errorf("htmlToText() = %q", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	setupTestDB(t)
+	addTestPage(t, "aaa", "guides/Getting Started.md", "<!-- ID: aaa -->\n# Setup\nInstall the bananas first.\n")
+	addTestPage(t, "bbb", "titled.md", "<!-- ID: bbb -->\n---\ntitle: Fruit Guide\n---\nBananas <b>everywhere</b>.\n")
+	addTestPage(t, "ccc", "draft.md", "<!-- ID: ccc -->\n---\ndraft: true\n---\nSecret bananas.\n")
+
+	results, err := Search("banana", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make(map[string]string)
+	for _, result := range results {
+		titles[result.ID] = result.Title
+	}
+	if len(results) != 2 || titles["aaa"] != "Getting Started" || titles["bbb"] != "Fruit Guide" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	for _, result := range results {
+		if result.ID == "aaa" && (result.RelPath != "guides/Getting Started.md" || result.Link != "/p/guides/getting-started") {
+			t.Errorf("unexpected result: %+v", result)
+		}
+		if result.ID == "bbb" && !strings.HasPrefix(result.Snippet, "<mark>Bananas</mark> everywhere") {
+			t.Errorf("snippet = %q", result.Snippet)
+		}
+	}
+
+	if results, err := Search("missing", 0); err != nil || len(results) != 0 {
+		t.Errorf("Search(missing) = %+v, %v", results, err)
+	}
+}
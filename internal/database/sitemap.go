@@ -0,0 +1,79 @@
+package database
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Value type is []SitemapEntry
+var sitemapCache = atomic.Value{}
+
+// SitemapEntry is a page linked from the layout.
+type SitemapEntry struct {
+	Path    string    // root relative url, e.g. "/p/guides/setup"
+	LastMod time.Time // zero if unknown
+}
+
+// GetSitemap returns the pages linked from the layout, starting with the landing page.
+func GetSitemap() []SitemapEntry {
+	entries, _ := sitemapCache.Load().([]SitemapEntry)
+	return entries
+}
+
+// refreshSitemap rebuilds the sitemap from the cached layout. Unpublished pages are left out.
+func refreshSitemap() error {
+	layout := layoutCache.Load().(Layout)
+
+	// collect the linked pages in layout order, landing page first
+	var ids []string
+	seen := make(map[string]bool)
+	addID := func(id string) {
+		if id != "" && !seen[id] && IsPublished(id) {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	addID(layout.Landing.ID)
+	var addSidebar func(items []SidebarItem)
+	addSidebar = func(items []SidebarItem) {
+		for _, item := range items {
+			switch item.Type {
+			case "file":
+				addID(item.Meta.ID)
+			case "folder":
+				addSidebar(item.Contents)
+			}
+		}
+	}
+	addSidebar(layout.Sidebar)
+	for _, item := range layout.Footer {
+		if item.Type == "footer-file" {
+			addID(item.Meta.ID)
+		}
+	}
+
+	// get the last changed times, pages that no longer exist are skipped
+	var contents []ContentModel
+	if err := DB.Select("id", "updated").Where("id IN ? AND rel_path <> ?", ids, MISSING_FILE).Find(&contents).Error; err != nil {
+		return err
+	}
+	updated := make(map[string]time.Time, len(contents))
+	for _, content := range contents {
+		updated[content.ID] = content.Updated
+	}
+
+	entries := make([]SitemapEntry, 0, len(ids))
+	for _, id := range ids {
+		lastMod, ok := updated[id]
+		if !ok {
+			continue
+		}
+		path := PageURL(id)
+		if id == layout.Landing.ID {
+			path = "/"
+		}
+		entries = append(entries, SitemapEntry{Path: path, LastMod: lastMod})
+	}
+	sitemapCache.Store(entries)
+	return nil
+}
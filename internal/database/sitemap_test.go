@@ -0,0 +1,42 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSitemap(t *testing.T) {
+	setupTestDB(t)
+	home := addTestPage(t, "home", "home.md", "<!-- ID: home -->\n# Home\n")
+	setup := addTestPage(t, "setup", "guides/setup.md", "<!-- ID: setup -->\n# Setup\n")
+	about := addTestPage(t, "about", "about.md", "<!-- ID: about -->\n# About\n")
+	addTestPage(t, "draft", "draft.md", "<!-- ID: draft -->\n---\ndraft: true\n---\n")
+	addTestPage(t, "unlinked", "unlinked.md", "<!-- ID: unlinked -->\n")
+
+	layout := Layout{
+		Sidebar: []SidebarItem{
+			{Name: "Guides", Type: "folder", Contents: []SidebarItem{
+				{Name: "Setup", Type: "file", Meta: setup.ContentMeta},
+				{Name: "Draft", Type: "file", Meta: ContentMeta{ID: "draft"}},
+				{Name: "Gone", Type: "file", Meta: ContentMeta{ID: "gone"}},
+			}},
+			{Type: "divider"},
+			{Name: "Home again", Type: "file", Meta: home.ContentMeta},
+		},
+		Footer:  []FooterItem{{Name: "About", Type: "footer-file", Meta: about.ContentMeta}, {Name: "Link", Type: "footer-link", Link: "https://example.com"}},
+		Landing: home.ContentMeta,
+	}
+	if err := SetLayout(&layout, "test"); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range GetSitemap() {
+		paths = append(paths, entry.Path)
+		if entry.LastMod.IsZero() {
+			t.Errorf("%s has no last modified time", entry.Path)
+		}
+	}
+	if want := []string{"/", "/p/guides/setup", "/p/about"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("sitemap = %v, want %v", paths, want)
+	}
+}
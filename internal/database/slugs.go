@@ -0,0 +1,248 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// Value type is map[string]string, key: id, value: current slug
+	slugCache      = atomic.Value{}
+	slugCleanRegex = regexp.MustCompile(`[^a-z0-9/]+`)
+)
+
+// SlugModel maps a slug to a page id. Non current slugs are kept so old links can be redirected.
+type SlugModel struct {
+	Slug      string `gorm:"primaryKey"`
+	ID        string `gorm:"index"`
+	IsCurrent bool
+}
+
+// PageURL returns the url for the page with the given id, e.g. "/p/guides/setup".
+// Falls back to "/page?id=" if the page has no slug.
+func PageURL(id string) string {
+	if slugs, ok := slugCache.Load().(map[string]string); ok {
+		if slug, exists := slugs[id]; exists {
+			return "/p/" + slug
+		}
+	}
+	return "/page?id=" + id
+}
+
+// pageURL is PageURL reading the current slug from db instead of the cache, for use before the cache is reloaded.
+func pageURL(db *gorm.DB, id string) string {
+	var slugs []string
+	if err := db.Model(&SlugModel{}).Where("id = ? AND is_current = ?", id, true).Limit(1).Pluck("slug", &slugs).Error; err != nil || len(slugs) == 0 {
+		return PageURL(id)
+	}
+	return "/p/" + slugs[0]
+}
+
+// ResolveSlug returns the id the given slug points to and whether the slug is the current one for that page.
+// If the slug is unknown, ("", false, nil) is returned.
+func ResolveSlug(slug string) (string, bool, error) {
+	var model SlugModel
+	if err := DB.Where("slug = ?", strings.Trim(slug, "/")).First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return model.ID, model.IsCurrent, nil
+}
+
+// Slugify converts a relative path into a slug, e.g. "Guides/Getting Started.md" -> "guides/getting-started".
+func Slugify(relPath string) string {
+	return cleanSlug(strings.TrimSuffix(filepath.ToSlash(relPath), filepath.Ext(relPath)))
+}
+
+// cleanSlug lowercases the slash separated path and replaces everything but letters and digits in each segment with dashes.
+func cleanSlug(path string) string {
+	slug := slugCleanRegex.ReplaceAllString(strings.ToLower(path), "-")
+	parts := strings.Split(slug, "/")
+	cleaned := parts[:0]
+	for _, part := range parts {
+		if part = strings.Trim(part, "-"); part != "" {
+			cleaned = append(cleaned, part)
+		}
+	}
+	return strings.Join(cleaned, "/")
+}
+
+// slugFits returns whether the slug is the base slug or the base with a collision suffix, e.g. "setup-2" for "setup".
+func slugFits(slug, base string) bool {
+	if slug == base {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(slug, base+"-")
+	if !ok {
+		return false
+	}
+	n, err := strconv.Atoi(suffix)
+	return err == nil && n >= 2 && strconv.Itoa(n) == suffix
+}
+
+// initSlugs generates slugs for existing content if there are none yet, then fills the cache.
+func initSlugs() error {
+	var count int64
+	if err := DB.Model(&SlugModel{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		metaDatas, err := GetMeta()
+		if err != nil {
+			return err
+		}
+		overrides, err := storedSlugOverrides(DB)
+		if err != nil {
+			return err
+		}
+		if _, err := updateSlugs(DB, metaDatas, overrides); err != nil {
+			return err
+		}
+	}
+	return loadSlugCache()
+}
+
+// updateSlugs sets the current slug for every page, see planSlugs and applySlugs. Returns the ids of pages whose slug changed.
+// The cache isn't reloaded, see loadSlugCache.
+func updateSlugs(db *gorm.DB, metaDatas []ContentMeta, overrides map[string]string) ([]string, error) {
+	slugs, changed, err := planSlugs(db, metaDatas, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return changed, applySlugs(db, slugs, changed)
+}
+
+// planSlugs returns the current slug of every page, by id, prefixed by the mount of its source, and the ids of pages
+// whose slug changed. Overrides are the slugs set in the front matter of pages, by id, used instead of the one from
+// the file path. Pages with missing files keep whatever slugs they already have. Nothing is written, see applySlugs.
+func planSlugs(db *gorm.DB, metaDatas []ContentMeta, overrides map[string]string) (map[string]string, []string, error) {
+	var current []SlugModel
+	if err := db.Where("is_current = ?", true).Find(&current).Error; err != nil {
+		return nil, nil, err
+	}
+	currentByID := make(map[string]string, len(current))
+	for _, model := range current {
+		currentByID[model.ID] = model.Slug
+	}
+
+	// sort for stable collision handling, missing pages hold on to their slugs
+	slugs := make(map[string]string, len(metaDatas))
+	taken := make(map[string]bool, len(metaDatas))
+	sorted := make([]ContentMeta, 0, len(metaDatas))
+	for _, metaData := range metaDatas {
+		if metaData.RelPath != MISSING_FILE {
+			sorted = append(sorted, metaData)
+		} else if slug, ok := currentByID[metaData.ID]; ok {
+			slugs[metaData.ID] = slug
+			taken[slug] = true
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].RelPath < sorted[j].RelPath })
+
+	// pages keep their slug if it still fits, before new pages claim one, so adding a page never takes a slug from another
+	bases := make(map[string]string, len(sorted))
+	for _, metaData := range sorted {
+		source := sourceOf(metaData.ID)
+		base := cleanSlug(overrides[metaData.ID])
+		if base == "" {
+			base = Slugify(metaData.RelPath)
+		}
+		if base == "" {
+			base = strings.ToLower(strings.TrimPrefix(metaData.ID, source+":"))
+		}
+		if mount := sourceMount(source); mount != "" {
+			base = mount + "/" + base
+		}
+		bases[metaData.ID] = base
+		if slug, ok := currentByID[metaData.ID]; ok && slugFits(slug, base) && !taken[slug] {
+			slugs[metaData.ID] = slug
+			taken[slug] = true
+		}
+	}
+
+	var changed []string
+	for _, metaData := range sorted {
+		if _, ok := slugs[metaData.ID]; ok {
+			continue
+		}
+		base := bases[metaData.ID]
+		slug := base
+		for i := 2; taken[slug]; i++ {
+			slug = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken[slug] = true
+		slugs[metaData.ID] = slug
+		if currentByID[metaData.ID] != slug {
+			changed = append(changed, metaData.ID)
+		}
+	}
+	return slugs, changed, nil
+}
+
+// applySlugs stores the slugs of the changed ids from planSlugs. Previous slugs are kept as redirects.
+func applySlugs(db *gorm.DB, slugs map[string]string, changed []string) error {
+	for _, id := range changed {
+		// demote the old slug, then claim the new one (possibly taking over another page's old redirect)
+		if err := db.Model(&SlugModel{}).Where("id = ?", id).Update("is_current", false).Error; err != nil {
+			return err
+		}
+		if err := db.Save(&SlugModel{Slug: slugs[id], ID: id, IsCurrent: true}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedSlugOverrides returns the front matter slugs of the pages in db, by id.
+func storedSlugOverrides(db *gorm.DB) (map[string]string, error) {
+	var contents []ContentModel
+	if err := db.Select("id", "front_slug").Where("front_slug IS NOT NULL AND front_slug <> ''").Find(&contents).Error; err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]string, len(contents))
+	for _, content := range contents {
+		overrides[content.ID] = content.Front.Slug
+	}
+	return overrides, nil
+}
+
+// slugOverrides returns the front matter slugs of the pages, by id. The front matter is only stored once a page is
+// saved, so the pages loaded for an update use theirs, the rest are read from db.
+func slugOverrides(db *gorm.DB, contents map[string]*ContentModel) (map[string]string, error) {
+	overrides, err := storedSlugOverrides(db)
+	if err != nil {
+		return nil, err
+	}
+	for id, content := range contents {
+		overrides[id] = content.Front.Slug
+	}
+	return overrides, nil
+}
+
+// cleanupSlugs deletes all slugs for ids not in the given list. The cache isn't reloaded, see loadSlugCache.
+func cleanupSlugs(db *gorm.DB, ids []string) error {
+	return db.Where("id NOT IN ?", ids).Delete(&SlugModel{}).Error
+}
+
+func loadSlugCache() error {
+	var current []SlugModel
+	if err := DB.Where("is_current = ?", true).Find(&current).Error; err != nil {
+		return err
+	}
+	slugs := make(map[string]string, len(current))
+	for _, model := range current {
+		slugs[model.ID] = model.Slug
+	}
+	slugCache.Store(slugs)
+	return nil
+}
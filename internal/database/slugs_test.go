@@ -0,0 +1,180 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		relPath string
+		want    string
+	}{
+		{"index.md", "index"},
+		{"Guides/Getting Started.md", "guides/getting-started"},
+		{"a/b/C++ & Go!.md", "a/b/c-go"},
+		{"--weird--/__name__.md", "weird/name"},
+		{"ünïcode.md", "n-code"},
+		{"___.md", ""},
+		{"notes.v2.md", "notes-v2"},
+	}
+	for _, test := range tests {
+		if got := Slugify(test.relPath); got != test.want {
+			t.Errorf("Slugify(%q) = %q, want %q", test.relPath, got, test.want)
+		}
+	}
+}
+
+// currentSlugs returns the current slug of every page by id.
+func currentSlugs(t *testing.T) map[string]string {
+	t.Helper()
+	var models []SlugModel
+	if err := DB.Where("is_current = ?", true).Find(&models).Error; err != nil {
+		t.Fatal(err)
+	}
+	slugs := make(map[string]string)
+	for _, model := range models {
+		slugs[model.ID] = model.Slug
+	}
+	return slugs
+}
+
+func TestUpdateSlugs(t *testing.T) {
+	setupTestDB(t)
+	utils.Config.Sources = []utils.ContentSource{{Name: "blog", URL: "x", Mount: "blog"}}
+
+	metaDatas := []ContentMeta{
+		{ID: "aaa", RelPath: "guides/Setup.md"},
+		{ID: "bbb", RelPath: "guides/setup.md"},
+		{ID: "ccc", RelPath: "other.md"},
+		{ID: "ddd", RelPath: "___.md"},
+		{ID: "blog:eee", RelPath: "post.md"},
+	}
+	changed, err := updateSlugs(DB, metaDatas, map[string]string{"ccc": "/Custom Path/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 5 {
+		t.Errorf("changed = %v", changed)
+	}
+	want := map[string]string{"aaa": "guides/setup", "bbb": "guides/setup-2", "ccc": "custom-path", "ddd": "ddd", "blog:eee": "blog/post"}
+	for id, slug := range currentSlugs(t) {
+		if want[id] != slug {
+			t.Errorf("slug of %s = %q, want %q", id, slug, want[id])
+		}
+	}
+
+	// moving a page keeps the old slug as a redirect, missing pages keep their slug, and the rest are unchanged
+	metaDatas[0].RelPath = MISSING_FILE
+	metaDatas[3].RelPath = "moved.md"
+	changed, err = updateSlugs(DB, metaDatas, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("changed = %v, want ccc and ddd", changed)
+	}
+	slugs := currentSlugs(t)
+	if slugs["aaa"] != "guides/setup" || slugs["bbb"] != "guides/setup-2" || slugs["ccc"] != "other" || slugs["ddd"] != "moved" {
+		t.Errorf("unexpected slugs: %v", slugs)
+	}
+	if id, current, err := ResolveSlug("/custom-path/"); err != nil || id != "ccc" || current {
+		t.Errorf("ResolveSlug(custom-path) = %s, %v, %v", id, current, err)
+	}
+	if id, current, err := ResolveSlug("moved"); err != nil || id != "ddd" || !current {
+		t.Errorf("ResolveSlug(moved) = %s, %v, %v", id, current, err)
+	}
+	if id, _, err := ResolveSlug("unknown"); err != nil || id != "" {
+		t.Errorf("ResolveSlug(unknown) = %s, %v", id, err)
+	}
+
+	// cleanup forgets removed pages
+	if err := cleanupSlugs(DB, []string{"bbb", "ccc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSlugCache(); err != nil {
+		t.Fatal(err)
+	}
+	if PageURL("ccc") != "/p/other" || PageURL("ddd") != "/page?id=ddd" {
+		t.Errorf("PageURL = %s, %s", PageURL("ccc"), PageURL("ddd"))
+	}
+}
+
+func TestUpdateSlugsKeepsExisting(t *testing.T) {
+	setupTestDB(t)
+	if _, err := updateSlugs(DB, []ContentMeta{{ID: "aaa", RelPath: "guides/setup.md"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new page that sorts first and wants the same slug gets a suffix instead of taking it
+	metaDatas := []ContentMeta{{ID: "aaa", RelPath: "guides/setup.md"}, {ID: "bbb", RelPath: "guides/Setup.md"}}
+	if _, err := updateSlugs(DB, metaDatas, nil); err != nil {
+		t.Fatal(err)
+	}
+	if slugs := currentSlugs(t); slugs["aaa"] != "guides/setup" || slugs["bbb"] != "guides/setup-2" {
+		t.Errorf("unexpected slugs: %v", slugs)
+	}
+
+	// a suffixed slug is kept while it fits, and dropped once the page moves
+	metaDatas[0].RelPath = MISSING_FILE
+	if _, err := updateSlugs(DB, metaDatas, nil); err != nil {
+		t.Fatal(err)
+	}
+	if slugs := currentSlugs(t); slugs["bbb"] != "guides/setup-2" {
+		t.Errorf("unexpected slugs: %v", slugs)
+	}
+	metaDatas[1].RelPath = "Setup.md"
+	if _, err := updateSlugs(DB, metaDatas, nil); err != nil {
+		t.Fatal(err)
+	}
+	if slugs := currentSlugs(t); slugs["aaa"] != "guides/setup" || slugs["bbb"] != "setup" {
+		t.Errorf("unexpected slugs: %v", slugs)
+	}
+}
+
+func TestSlugFits(t *testing.T) {
+	tests := []struct {
+		slug, base string
+		want       bool
+	}{
+		{"setup", "setup", true},
+		{"setup-2", "setup", true},
+		{"setup-12", "setup", true},
+		{"setup-1", "setup", false},
+		{"setup-02", "setup", false},
+		{"setup-x", "setup", false},
+		{"setup-guide", "setup", false},
+		{"other", "setup", false},
+	}
+	for _, test := range tests {
+		if got := slugFits(test.slug, test.base); got != test.want {
+			t.Errorf("slugFits(%q, %q) = %v, want %v", test.slug, test.base, got, test.want)
+		}
+	}
+}
+
+func TestSlugOverrides(t *testing.T) {
+	setupTestDB(t)
+	addTestPage(t, "aaa", "a.md", "<!-- ID: aaa -->\n---\nslug: start\n---\n# A\n")
+	addTestPage(t, "bbb", "b.md", "<!-- ID: bbb -->\n+++\nslug = \"Guides/Setup\"\n+++\n# B\n")
+	addTestPage(t, "ccc", "c.md", "<!-- ID: ccc -->\n# C\n")
+	if PageURL("aaa") != "/p/start" || PageURL("bbb") != "/p/guides/setup" || PageURL("ccc") != "/p/c" {
+		t.Errorf("PageURL = %s, %s, %s", PageURL("aaa"), PageURL("bbb"), PageURL("ccc"))
+	}
+
+	// a new page asking for a slug that's taken doesn't get it, even if it sorts first
+	addTestPage(t, "000", "0.md", "<!-- ID: 000 -->\n---\nslug: start\n---\n# 0\n")
+	if PageURL("aaa") != "/p/start" || PageURL("000") != "/p/start-2" {
+		t.Errorf("PageURL = %s, %s", PageURL("aaa"), PageURL("000"))
+	}
+
+	// loaded pages use their new front matter, the rest keep the slug from the db
+	loaded := map[string]*ContentModel{"bbb": {Front: peekFrontMatter("<!-- ID: bbb -->\n+++\nslug = \"from-toml\"\n+++\n")}}
+	overrides, err := slugOverrides(DB, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overrides["aaa"] != "start" || overrides["bbb"] != "from-toml" || overrides["ccc"] != "" {
+		t.Errorf("slugOverrides() = %v", overrides)
+	}
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"errors"
+	"intermark/internal/files"
+	"intermark/internal/utils"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+)
+
+const LOCAL_COMMIT_PREFIX = "local-" // commits of local directories are this followed by the unix time in nanoseconds
+
+// syncedSource is a content source checked out for an update.
+type syncedSource struct {
+	utils.ContentSource
+	path       string    // local clone
+	commit     string    // checked out commit
+	commitTime time.Time // used as the updated time of changed pages
+	changes    changeSet // files changed since the source was last synced
+}
+
+// sourcePath returns the local clone or directory of the content source with the given name.
+func sourcePath(name string) string {
+	if source, ok := utils.Config.ContentSource(name); ok && source.Path != "" {
+		return source.Path
+	} else if name == "" {
+		return CONTENT_REPO_PATH
+	}
+	return filepath.Join(SOURCES_PATH, name)
+}
+
+// sourceLabel returns the content source with the given name as it's referred to in messages.
+func sourceLabel(name string) string {
+	return utils.Ternary(name == "", "the content repository", "content source '"+name+"'")
+}
+
+// sourceMount returns the url prefix of the content source with the given name, empty for the main repository.
+func sourceMount(name string) string {
+	source, _ := utils.Config.ContentSource(name)
+	return source.Mount
+}
+
+// sourceID namespaces an id from the given content source, ids from the main repository are left as is.
+func sourceID(source, id string) string {
+	if source == "" {
+		return id
+	}
+	return source + ":" + id
+}
+
+// sourceOf returns the name of the content source a namespaced id is from, empty for the main repository.
+func sourceOf(id string) string {
+	if source, _, found := strings.Cut(id, ":"); found {
+		return source
+	}
+	return ""
+}
+
+// linkID returns the id a link in a page from the given source points to.
+// Ids without a namespace are in the same source as the page, ":id" is in the main repository.
+func linkID(source, id string) string {
+	if strings.HasPrefix(id, ":") {
+		return id[1:]
+	} else if strings.Contains(id, ":") {
+		return id
+	}
+	return sourceID(source, id)
+}
+
+// sourceSyncer brings the local copy of a content source up to date, see gitSource and localSource.
+type sourceSyncer interface {
+	// sync updates the local copy to ref, or the latest content if ref is empty. Returns the commit and its time.
+	sync(ref string) (string, time.Time, error)
+	// changes returns the files changed since the source was last synced, nil to rebuild everything.
+	changes(commit string) (changeSet, error)
+}
+
+// newSourceSyncer returns the syncer for the content source, sources with a local path are read as is.
+func newSourceSyncer(source utils.ContentSource) sourceSyncer {
+	if source.Path != "" {
+		return localSource{source}
+	}
+	return gitSource{source}
+}
+
+// syncSource syncs the content source to ref, or the latest content if ref is empty, and finds the files changed since the last sync.
+func syncSource(source utils.ContentSource, ref string) (*syncedSource, error) {
+	syncer := newSourceSyncer(source)
+	synced := &syncedSource{ContentSource: source, path: sourcePath(source.Name)}
+	var err error
+	if synced.commit, synced.commitTime, err = syncer.sync(ref); err != nil {
+		return nil, err
+	}
+	if synced.changes, err = syncer.changes(synced.commit); err != nil {
+		return nil, err
+	}
+	return synced, nil
+}
+
+// gitSource is a content source cloned from its url.
+type gitSource struct {
+	utils.ContentSource
+}
+
+// sync clones or fetches the repository, then checks out ref, or the latest commit on its branch if ref is empty.
+func (g gitSource) sync(ref string) (string, time.Time, error) {
+	path, label := sourcePath(g.Name), sourceLabel(g.Name)
+	commit := ""
+	if exists, err := files.Exists(filepath.Join(path, ".git")); err != nil {
+		blog.Errorf("Error checking if a .git directory already exists: %v", err)
+		return "", time.Time{}, errors.New("error checking if a .git directory already exists")
+	} else if !exists {
+		if commit, err = utils.GitClone(g.URL, path, g.Branch); err != nil {
+			blog.Errorf("Error cloning %s: %v", label, err)
+			return "", time.Time{}, errors.New("error cloning " + label)
+		}
+		blog.Debugf(`Cloned: '%s', commit: '%s'`, g.URL, commit)
+	}
+	if commit == "" || ref != "" {
+		var err error
+		if commit, err = utils.GitReset(path, g.Branch, ref); err != nil {
+			blog.Errorf("Error resetting %s: %v", label, err)
+			return "", time.Time{}, errors.New("error resetting " + label + " to " + utils.Ternary(ref == "", "the latest commit", "'"+ref+"'"))
+		}
+		blog.Debugf(`Reset: '%s', pin: '%s', commit: '%s'`, g.URL, ref, commit)
+	}
+
+	// get the commit time, used as the updated time of changed pages
+	commitTime, err := utils.GitCommitTime(path, commit)
+	if err != nil {
+		blog.Errorf("Error getting commit time: %v", err)
+		return "", time.Time{}, errors.New("error getting commit time")
+	}
+	return commit, commitTime, nil
+}
+
+// changes diffs the commit against the last synced one in one pass, see changedSince.
+func (g gitSource) changes(commit string) (changeSet, error) {
+	return changedSince(g.Name, sourcePath(g.Name), commit), nil
+}
+
+// localSource is a content source read from its local directory. There's no history, so the commit is the current time.
+type localSource struct {
+	utils.ContentSource
+}
+
+// sync checks the directory exists, ref is ignored.
+func (l localSource) sync(ref string) (string, time.Time, error) {
+	if exists, err := files.Exists(l.Path); err != nil {
+		blog.Errorf("Error checking if the local content directory exists: %v", err)
+		return "", time.Time{}, errors.New("error checking if the local content directory exists")
+	} else if !exists {
+		blog.Errorf("Local content directory not found: %s", l.Path)
+		return "", time.Time{}, errors.New("local content directory of " + sourceLabel(l.Name) + " not found")
+	}
+	now := time.Now()
+	return LOCAL_COMMIT_PREFIX + strconv.FormatInt(now.UnixNano(), 10), now, nil
+}
+
+// changes returns the files modified since the time in the last synced commit, everything is rebuilt if it wasn't from this directory.
+func (l localSource) changes(commit string) (changeSet, error) {
+	last, err := GetLastSyncedCommit(l.Name)
+	if err != nil {
+		blog.Errorf("Error getting the last synced commit, rebuilding everything: %v", err)
+		return nil, nil
+	}
+	since, err := strconv.ParseInt(strings.TrimPrefix(last, LOCAL_COMMIT_PREFIX), 10, 64)
+	if !strings.HasPrefix(last, LOCAL_COMMIT_PREFIX) || err != nil {
+		blog.Infof("No local sync of %s, rebuilding everything", sourceLabel(l.Name))
+		return nil, nil
+	}
+	changes := make(changeSet)
+	err = filepath.WalkDir(l.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if entry.IsDir() {
+			return utils.Ternary(entry.Name() == ".git", filepath.SkipDir, nil)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().UnixNano() > since {
+			relPath, err := filepath.Rel(l.Path, path)
+			if err != nil {
+				return err
+			}
+			changes[filepath.ToSlash(relPath)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		blog.Errorf("Error listing modified files in %s: %v", l.Path, err)
+		return nil, errors.New("error listing modified files in the local content directory")
+	}
+	blog.Debugf("%d files modified in %s", len(changes), l.Path)
+	return changes, nil
+}
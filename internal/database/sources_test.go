@@ -0,0 +1,164 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceIDs(t *testing.T) {
+	if sourceID("", "aaa") != "aaa" || sourceID("blog", "aaa") != "blog:aaa" {
+		t.Errorf("sourceID = %s, %s", sourceID("", "aaa"), sourceID("blog", "aaa"))
+	}
+	if sourceOf("aaa") != "" || sourceOf("blog:aaa") != "blog" {
+		t.Errorf("sourceOf = %s, %s", sourceOf("aaa"), sourceOf("blog:aaa"))
+	}
+	tests := []struct {
+		source, id, want string
+	}{
+		{"", "aaa", "aaa"},
+		{"blog", "aaa", "blog:aaa"},  // same source
+		{"blog", ":aaa", "aaa"},      // main repository
+		{"", "blog:aaa", "blog:aaa"}, // another source
+		{"blog", "news:aaa", "news:aaa"},
+	}
+	for _, test := range tests {
+		if got := linkID(test.source, test.id); got != test.want {
+			t.Errorf("linkID(%q, %q) = %q, want %q", test.source, test.id, got, test.want)
+		}
+	}
+}
+
+func TestSourceUpdate(t *testing.T) {
+	setupTestDB(t)
+	setupTestContent(t, map[string]string{
+		".github/ids.json": `{"home": "home.md"}`,
+		"home.md":          "<!-- ID: home -->\n# Home\n[[blog:post]]\n",
+	})
+	blogDir := t.TempDir()
+	runTestGit(t, blogDir, "init", "-b", "main")
+	writeTestFiles(t, blogDir, map[string]string{
+		".github/ids.json": `{"post": "post.md", "other": "other.md"}`,
+		"post.md":          "<!-- ID: post -->\n---\ntitle: First Post\n---\n[[:home]] [[other]]\n",
+		"other.md":         "<!-- ID: other -->\n# Other\n",
+		"assets/logo.png":  "logo",
+	})
+	commitTestFiles(t, blogDir)
+	utils.Config.Sources = []utils.ContentSource{{Name: "blog", URL: blogDir, Mount: "news/blog"}}
+	if err := runUpdate(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// pages of the source are namespaced and mounted, links between sources resolve
+	home, err := GetContent("home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(home.HTML, `<a href="/p/news/blog/post">First Post</a>`) {
+		t.Errorf("home html = %s", home.HTML)
+	}
+	post, err := GetContent("blog:post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Source != "blog" || !strings.Contains(post.HTML, `href="/p/home"`) || !strings.Contains(post.HTML, `href="/p/news/blog/other"`) {
+		t.Errorf("post = %+v", post)
+	}
+	assertFile(t, filepath.Join(ASSETS_PATH, "news", "blog", "assets", "logo.png"), "logo")
+}
+
+func TestNewSourceSyncer(t *testing.T) {
+	if _, ok := newSourceSyncer(utils.ContentSource{URL: "https://example.com/docs.git", Path: "/srv/docs"}).(localSource); !ok {
+		t.Error("source with a path isn't read locally")
+	}
+	if _, ok := newSourceSyncer(utils.ContentSource{URL: "https://example.com/docs.git"}).(gitSource); !ok {
+		t.Error("source with a url isn't cloned")
+	}
+}
+
+func TestLocalSource(t *testing.T) {
+	setupTestDB(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.md": "a", "guides/b.md": "b", ".git/HEAD": "ref"})
+	source := localSource{utils.ContentSource{Path: dir}}
+	if _, _, err := (localSource{utils.ContentSource{Path: filepath.Join(dir, "missing")}}).sync(""); err == nil {
+		t.Error("synced a missing directory")
+	}
+	commit, commitTime, err := source.sync("")
+	if err != nil || !strings.HasPrefix(commit, LOCAL_COMMIT_PREFIX) || time.Since(commitTime) > time.Minute {
+		t.Fatalf("sync() = %s, %s, %v", commit, commitTime, err)
+	}
+
+	// everything is rebuilt until the directory has been synced, then files modified since are changed
+	if changes, err := source.changes(commit); err != nil || changes != nil {
+		t.Errorf("changes() before a sync = %v, %v", changes, err)
+	}
+	if err := recordSync("", commit, ""); err != nil {
+		t.Fatal(err)
+	}
+	later := commitTime.Add(time.Second)
+	for _, relPath := range []string{"guides/b.md", ".git/HEAD"} {
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(relPath)), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(dir, "a.md"), commitTime.Add(-time.Second), commitTime.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := source.changes(commit); err != nil || !reflect.DeepEqual(changes, changeSet{"guides/b.md": true}) {
+		t.Errorf("changes() = %v, %v", changes, err)
+	}
+
+	// a commit from a repository isn't a time
+	if err := recordSync("", "0123456789abcdef", ""); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := source.changes(commit); err != nil || changes != nil {
+		t.Errorf("changes() after a repository sync = %v, %v", changes, err)
+	}
+}
+
+func TestRecordLocalSync(t *testing.T) {
+	setupTestDB(t)
+	for _, commit := range []string{"aaa", LOCAL_COMMIT_PREFIX + "1", LOCAL_COMMIT_PREFIX + "2"} {
+		if err := recordSync("docs", commit, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordSync("blog", LOCAL_COMMIT_PREFIX+"1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the last local commit of the source is kept
+	var commits []string
+	if err := DB.Model(&SyncModel{}).Where("source = ?", "docs").Order("id").Pluck("commit", &commits).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(commits, []string{"aaa", LOCAL_COMMIT_PREFIX + "2"}) {
+		t.Errorf("commits = %v", commits)
+	}
+	if commit, err := GetLastSyncedCommit("blog"); err != nil || commit != LOCAL_COMMIT_PREFIX+"1" {
+		t.Errorf("GetLastSyncedCommit(blog) = %q, %v", commit, err)
+	}
+}
+
+func TestBackfillLocalSource(t *testing.T) {
+	setupTestDB(t)
+	utils.Config.Sources = []utils.ContentSource{{Name: "docs", Path: t.TempDir()}}
+	content := ContentModel{ContentMeta: ContentMeta{ID: "docs:page", RelPath: "page.md", Commit: LOCAL_COMMIT_PREFIX + "1"}, Source: "docs"}
+	if err := DB.Create(&content).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// there's no history to read the time from, so the page is left alone
+	if err := backfillUpdated(DB); err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.First(&content, "id = ?", "docs:page").Error; err != nil || !content.Updated.IsZero() {
+		t.Errorf("content = %+v, %v", content, err)
+	}
+}
@@ -0,0 +1,315 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"intermark/internal/files"
+	"intermark/internal/utils"
+	"io/fs"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+	"gorm.io/gorm"
+)
+
+// SyncModel is a commit a content source was successfully updated to.
+type SyncModel struct {
+	ID        uint      `json:"ID" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	Source    string    `json:"Source" gorm:"index"` // name of the content source, empty for the main repository
+	Commit    string    `json:"Commit"`
+	Pin       string    `json:"Pin"` // ref the site was pinned to, empty if it followed the branch
+}
+
+// PinModel is the pin set in the editor, a single row that takes precedence over the config, see initPin.
+type PinModel struct {
+	ID        uint `gorm:"primaryKey"`
+	UpdatedAt time.Time
+	Ref       string // commit hash or tag, empty to follow the branch
+	Author    string
+}
+
+const (
+	MAX_SYNC_HISTORY = 20 // commits listed by GetSyncHistory
+	WATCH_INTERVAL   = 2 * time.Second
+)
+
+var (
+	// Value type is string, commit hash or tag the site is pinned to, empty to follow the branch
+	pinCache = atomic.Value{}
+	// PinOverride is the pin from the -pin argument, used for this run instead of the stored or configured one
+	PinOverride = ""
+)
+
+// initPin loads the pin into the cache: PinOverride if set, else the one set in the editor, else the one in the config.
+func initPin() error {
+	pin := utils.Config.ContentRepo.Pin
+	var stored []PinModel
+	if err := DB.Limit(1).Find(&stored).Error; err != nil {
+		return err
+	} else if len(stored) != 0 {
+		pin = stored[0].Ref
+	}
+	if PinOverride != "" {
+		pin = PinOverride
+	}
+	if pin != "" && !utils.ValidGitRef(pin) {
+		return errors.New("invalid pin: '" + pin + "'")
+	}
+	pinCache.Store(pin)
+	return nil
+}
+
+// GetPin returns the commit hash or tag the site is pinned to, empty if it follows the branch.
+func GetPin() string {
+	pin, _ := pinCache.Load().(string)
+	return pin
+}
+
+// SetPin pins the site to the given commit hash or tag, or unpins it if ref is empty, and stores it so it's kept
+// across restarts. The ref is checked against the content repository if it's been cloned. Takes effect on the next update.
+func SetPin(ref, author string) error {
+	if ref != "" {
+		if utils.Config.ContentRepo.Path != "" {
+			return errors.New("a local content directory can't be pinned")
+		} else if !utils.ValidGitRef(ref) {
+			return errors.New("invalid commit or tag")
+		}
+		UpdateMutex.Lock()
+		defer UpdateMutex.Unlock()
+		if exists, err := files.Exists(filepath.Join(CONTENT_REPO_PATH, ".git")); err != nil {
+			blog.Errorf("Error checking if a .git directory already exists: %v", err)
+			return errors.New("error checking the content repository")
+		} else if exists {
+			if _, err := utils.GitResolve(CONTENT_REPO_PATH, utils.Config.ContentRepo.Branch, ref); err != nil {
+				blog.Warnf("Error resolving pin: %v", err)
+				return errors.New("unknown commit or tag '" + ref + "'")
+			}
+		}
+	}
+	if err := DB.Save(&PinModel{ID: 1, Ref: ref, Author: author}).Error; err != nil {
+		blog.Errorf("Error saving pin: %v", err)
+		return errors.New("error saving pin")
+	}
+	pinCache.Store(ref)
+	blog.Infof("Pin set to '%s' by %s", ref, author)
+	return nil
+}
+
+// GetSyncHistory returns the most recent commits the main repository was updated to, newest first.
+func GetSyncHistory() ([]SyncModel, error) {
+	var history []SyncModel
+	if err := DB.Where("source = ?", "").Order("id DESC").Limit(MAX_SYNC_HISTORY).Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// GetLastSyncedCommit returns the commit the content source was last successfully updated to, empty if there hasn't been one.
+func GetLastSyncedCommit(source string) (string, error) {
+	var model SyncModel
+	if err := DB.Where("source = ?", source).Order("id DESC").First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return model.Commit, nil
+}
+
+// recordSync stores the commit a content source was updated to, if it differs from the last one.
+// Local directories have a new commit every update, so only their last one is kept.
+func recordSync(source, commit, pin string) error {
+	last, err := GetLastSyncedCommit(source)
+	if err != nil || last == commit {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if strings.HasPrefix(commit, LOCAL_COMMIT_PREFIX) {
+			if err := tx.Where("source = ? AND `commit` LIKE ?", source, LOCAL_COMMIT_PREFIX+"%").Delete(&SyncModel{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&SyncModel{Source: source, Commit: commit, Pin: pin}).Error
+	})
+}
+
+// changeSet is the set of slash separated paths that changed between two commits. Nil means everything changed.
+type changeSet map[string]bool
+
+// changedSince returns the files changed between the last synced commit of the source and commit, found in one pass.
+// Returns nil to rebuild everything if there's no synced commit or it can't be diffed, e.g. history was
+// rewritten and it's gone. Trees are compared directly, so it doesn't matter if commit descends from it.
+func changedSince(source, repoPath, commit string) changeSet {
+	last, err := GetLastSyncedCommit(source)
+	if err != nil {
+		blog.Errorf("Error getting the last synced commit, rebuilding everything: %v", err)
+		return nil
+	} else if last == "" {
+		blog.Info("No synced commit, rebuilding everything")
+		return nil
+	}
+	paths, err := utils.GitChangedFiles(repoPath, last, commit)
+	if err != nil {
+		blog.Warnf("Error diffing against the last synced commit '%s', rebuilding everything: %v", last, err)
+		return nil
+	}
+	blog.Debugf("%d files changed since '%s'", len(paths), last)
+	changes := make(changeSet, len(paths))
+	for _, path := range paths {
+		changes[path] = true
+	}
+	return changes
+}
+
+// changed returns true if the file at relPath needs rebuilding. Commit is the one it was last built from, empty if never.
+func (c changeSet) changed(relPath, commit string) bool {
+	return c == nil || commit == "" || c[filepath.ToSlash(relPath)]
+}
+
+// fetchHeads fetches every content source and returns their new HEADs by name, empty if one hasn't been cloned yet.
+// Local directories are skipped, see StartWatcher.
+func fetchHeads() (map[string]string, error) {
+	UpdateMutex.Lock()
+	defer UpdateMutex.Unlock()
+	heads := make(map[string]string)
+	for _, source := range utils.Config.ContentSources() {
+		if source.Path != "" {
+			continue
+		}
+		path := sourcePath(source.Name)
+		if exists, err := files.Exists(filepath.Join(path, ".git")); err != nil {
+			return nil, err
+		} else if !exists {
+			heads[source.Name] = ""
+			continue
+		}
+		head, err := utils.GitReset(path, source.Branch, utils.Ternary(source.Name == "", GetPin(), ""))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sourceLabel(source.Name), err)
+		}
+		heads[source.Name] = head
+	}
+	return heads, nil
+}
+
+// StartPoller periodically fetches the content sources and queues an update when a new commit is found.
+// Does nothing unless content_repo > poll_interval is set.
+func StartPoller() {
+	cfg := utils.Config.ContentRepo
+	if cfg.PollInterval <= 0 {
+		return
+	}
+	interval := time.Duration(cfg.PollInterval) * time.Second
+	maxBackoff := time.Duration(cfg.PollMaxBackoff) * time.Second
+	blog.Infof("Polling the content sources every %s", interval)
+	go func() {
+		delay := interval
+		queued := make(map[string]string) // commits last queued by source, failed updates aren't retried until there's a new one
+		for {
+			wait := delay
+			if cfg.PollJitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(cfg.PollJitter)*int64(time.Second) + 1))
+			}
+			time.Sleep(wait)
+			heads, err := fetchHeads()
+			if err != nil {
+				delay = pollBackoff(delay, interval, maxBackoff)
+				blog.Errorf("Error polling the content sources, next try in %s: %v", delay, err)
+				continue
+			}
+			delay = interval
+			if !hasNewCommit(heads, queued) {
+				continue
+			}
+			if _, err := QueueUpdate(); err != nil {
+				blog.Errorf("Error queuing update: %v", err)
+				continue
+			}
+			queued = heads
+		}
+	}()
+}
+
+// pollBackoff returns the delay after a failed poll, doubling it up to maxBackoff, or interval if that's larger.
+func pollBackoff(delay, interval, maxBackoff time.Duration) time.Duration {
+	return min(delay*2, max(maxBackoff, interval))
+}
+
+// hasNewCommit returns true if a source has a HEAD that hasn't been synced or queued, or hasn't been cloned yet.
+func hasNewCommit(heads, queued map[string]string) bool {
+	found := false
+	for source, head := range heads {
+		last, err := GetLastSyncedCommit(source)
+		if err != nil {
+			blog.Errorf("Error getting the last synced commit: %v", err)
+		} else if head == "" || (head != last && head != queued[source]) {
+			blog.Infof("New commit '%s' found in %s", head, sourceLabel(source))
+			found = true
+		}
+	}
+	return found
+}
+
+// StartWatcher checks the local content directories for changes every WATCH_INTERVAL and queues an update when
+// a file is added, removed, or modified. An update is queued right away so the site starts out current.
+func StartWatcher() {
+	var local []utils.ContentSource
+	for _, source := range utils.Config.ContentSources() {
+		if source.Path != "" {
+			local = append(local, source)
+		}
+	}
+	if len(local) == 0 {
+		return
+	}
+	blog.Infof("Watching %d local content directories for changes", len(local))
+	go func() {
+		fingerprints := make(map[string]uint64)
+		for first := true; ; first = false {
+			changed := first
+			for _, source := range local {
+				fingerprint, err := dirFingerprint(source.Path)
+				if err != nil {
+					blog.Errorf("Error checking %s for changes: %v", source.Path, err)
+					continue
+				}
+				if old, ok := fingerprints[source.Name]; ok && old != fingerprint {
+					blog.Infof("Changes found in %s", source.Path)
+					changed = true
+				}
+				fingerprints[source.Name] = fingerprint
+			}
+			if changed {
+				if _, err := QueueUpdate(); err != nil {
+					blog.Errorf("Error queuing update: %v", err)
+				}
+			}
+			time.Sleep(WATCH_INTERVAL)
+		}
+	}()
+}
+
+// dirFingerprint hashes the path, size, and modified time of every file in the directory, skipping .git.
+func dirFingerprint(dir string) (uint64, error) {
+	hash := fnv.New64a()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if entry.IsDir() {
+			return utils.Ternary(entry.Name() == ".git", filepath.SkipDir, nil)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return hash.Sum64(), err
+}
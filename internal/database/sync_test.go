@@ -0,0 +1,252 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPollBackoff(t *testing.T) {
+	tests := []struct {
+		delay, interval, maxBackoff, want time.Duration
+	}{
+		{time.Minute, time.Minute, time.Hour, 2 * time.Minute},
+		{40 * time.Minute, time.Minute, time.Hour, time.Hour},
+		{time.Hour, time.Minute, time.Hour, time.Hour},
+		{time.Minute, time.Minute, 0, time.Minute}, // a max below the interval keeps the interval
+	}
+	for _, test := range tests {
+		if got := pollBackoff(test.delay, test.interval, test.maxBackoff); got != test.want {
+			t.Errorf("pollBackoff(%s, %s, %s) = %s, want %s", test.delay, test.interval, test.maxBackoff, got, test.want)
+		}
+	}
+}
+
+func TestHasNewCommit(t *testing.T) {
+	setupTestDB(t)
+	if err := recordSync("", "aaa", ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		heads, queued map[string]string
+		want          bool
+	}{
+		{map[string]string{"": "aaa"}, nil, false},
+		{map[string]string{"": "bbb"}, nil, true},
+		{map[string]string{"": "bbb"}, map[string]string{"": "bbb"}, false}, // failed updates wait for a new commit
+		{map[string]string{"": "ccc"}, map[string]string{"": "bbb"}, true},
+		{map[string]string{"": "aaa", "blog": ""}, nil, true}, // not cloned yet
+	}
+	for _, test := range tests {
+		if got := hasNewCommit(test.heads, test.queued); got != test.want {
+			t.Errorf("hasNewCommit(%v, %v) = %v, want %v", test.heads, test.queued, got, test.want)
+		}
+	}
+}
+
+func TestFetchHeads(t *testing.T) {
+	setupTestDB(t)
+	utils.Config.Sources = []utils.ContentSource{{Name: "docs", Path: t.TempDir()}, {Name: "blog", URL: "https://example.com/blog.git"}}
+	heads, err := fetchHeads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local directories are watched instead, sources that aren't cloned yet have no head
+	if _, ok := heads["docs"]; ok {
+		t.Error("fetched a local directory")
+	}
+	if len(heads) != 2 || heads[""] != "" || heads["blog"] != "" {
+		t.Errorf("fetchHeads() = %v", heads)
+	}
+}
+
+func TestSetPin(t *testing.T) {
+	setupTestDB(t)
+	t.Cleanup(func() { pinCache.Store("") })
+
+	// the ref can't be checked until the repository is cloned
+	if err := SetPin("v1.0", "admin"); err != nil || GetPin() != "v1.0" {
+		t.Fatalf("SetPin(v1.0) = %v, pin %q", err, GetPin())
+	}
+	for _, ref := range []string{"-v1", "v1..v2", "a b"} {
+		if err := SetPin(ref, "admin"); err == nil {
+			t.Errorf("SetPin(%q) succeeded", ref)
+		}
+	}
+	if GetPin() != "v1.0" {
+		t.Errorf("rejected pin replaced it: %q", GetPin())
+	}
+	if err := SetPin("", "admin"); err != nil || GetPin() != "" {
+		t.Errorf("unpinning = %v, pin %q", err, GetPin())
+	}
+
+	utils.Config.ContentRepo.Path = t.TempDir()
+	if err := SetPin("v1.0", "admin"); err == nil {
+		t.Error("pinned a local content directory")
+	}
+}
+
+func TestInitPin(t *testing.T) {
+	setupTestDB(t)
+	t.Cleanup(func() { pinCache.Store(""); PinOverride = "" })
+	utils.Config.ContentRepo.Pin = "v1.0"
+	if err := initPin(); err != nil || GetPin() != "v1.0" {
+		t.Errorf("config pin = %q, %v", GetPin(), err)
+	}
+
+	// pins set in the editor are kept across restarts, over the config, even to follow the branch
+	if err := SetPin("", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	pinCache.Store("")
+	if err := initPin(); err != nil || GetPin() != "" {
+		t.Errorf("stored pin = %q, %v", GetPin(), err)
+	}
+	if utils.Config.ContentRepo.Pin != "v1.0" {
+		t.Errorf("config changed: %q", utils.Config.ContentRepo.Pin)
+	}
+
+	// -pin applies over both
+	PinOverride = "v2.0"
+	if err := initPin(); err != nil || GetPin() != "v2.0" {
+		t.Errorf("override pin = %q, %v", GetPin(), err)
+	}
+	PinOverride = "-v2"
+	if err := initPin(); err == nil {
+		t.Error("loaded an invalid pin")
+	}
+}
+
+func TestSyncHistory(t *testing.T) {
+	setupTestDB(t)
+	if commit, err := GetLastSyncedCommit(""); err != nil || commit != "" {
+		t.Errorf("GetLastSyncedCommit() = %q, %v", commit, err)
+	}
+	for _, sync := range [][3]string{{"", "aaa", ""}, {"", "aaa", ""}, {"blog", "ccc", ""}, {"", "bbb", "v1.0"}} {
+		if err := recordSync(sync[0], sync[1], sync[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// repeated commits are recorded once, other sources aren't listed
+	history, err := GetSyncHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Commit != "bbb" || history[0].Pin != "v1.0" || history[1].Commit != "aaa" {
+		t.Errorf("history = %+v", history)
+	}
+	if commit, err := GetLastSyncedCommit("blog"); err != nil || commit != "ccc" {
+		t.Errorf("GetLastSyncedCommit(blog) = %q, %v", commit, err)
+	}
+}
+
+func TestChangeSet(t *testing.T) {
+	changes := changeSet{"guides/setup.md": true}
+	tests := []struct {
+		changes         changeSet
+		relPath, commit string
+		want            bool
+	}{
+		{changes, "guides/setup.md", "aaa", true},
+		{changes, "other.md", "aaa", false},
+		{changes, "other.md", "", true}, // never built
+		{nil, "other.md", "aaa", true},  // everything changed
+	}
+	for _, test := range tests {
+		if got := test.changes.changed(test.relPath, test.commit); got != test.want {
+			t.Errorf("%v.changed(%q, %q) = %v, want %v", test.changes, test.relPath, test.commit, got, test.want)
+		}
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	setupTestDB(t)
+	repo := CONTENT_REPO_PATH
+	head := func() string {
+		hash, err := utils.GitCommitHash(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	if err := os.MkdirAll(repo, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	runTestGit(t, repo, "init", "-b", "main")
+	writeTestFiles(t, repo, map[string]string{"a.md": "a", "b.md": "b"})
+	commitTestFiles(t, repo)
+	first := head()
+	writeTestFiles(t, repo, map[string]string{"b.md": "changed", "c.md": "c"})
+	commitTestFiles(t, repo)
+	second := head()
+
+	// everything is rebuilt without a synced commit, or one that can't be diffed
+	if changes := changedSince("", repo, second); changes != nil {
+		t.Errorf("changedSince() without a synced commit = %v", changes)
+	}
+	if err := recordSync("", "0123456789abcdef0123456789abcdef01234567", ""); err != nil {
+		t.Fatal(err)
+	}
+	if changes := changedSince("", repo, second); changes != nil {
+		t.Errorf("changedSince() from an unknown commit = %v", changes)
+	}
+	if err := recordSync("", first, ""); err != nil {
+		t.Fatal(err)
+	}
+	if changes := changedSince("", repo, second); !reflect.DeepEqual(changes, changeSet{"b.md": true, "c.md": true}) {
+		t.Errorf("changedSince() = %v", changes)
+	}
+}
+
+func TestDirFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.md": "a", "guides/b.md": "b"})
+	fingerprint := func() uint64 {
+		t.Helper()
+		hash, err := dirFingerprint(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	first := fingerprint()
+	if fingerprint() != first {
+		t.Fatal("fingerprint changed without changes")
+	}
+
+	// .git is skipped, adding, modifying, and removing files changes it
+	writeTestFiles(t, dir, map[string]string{".git/HEAD": "ref"})
+	if fingerprint() != first {
+		t.Error(".git changed the fingerprint")
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.md"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	modified := fingerprint()
+	if modified == first {
+		t.Error("modifying a file didn't change the fingerprint")
+	}
+	writeTestFiles(t, dir, map[string]string{"guides/c.md": "c"})
+	added := fingerprint()
+	if added == modified {
+		t.Error("adding a file didn't change the fingerprint")
+	}
+	if err := os.Remove(filepath.Join(dir, "guides", "c.md")); err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint() != modified {
+		t.Error("removing the added file didn't restore the fingerprint")
+	}
+	if _, err := dirFingerprint(filepath.Join(dir, "missing")); err == nil {
+		t.Error("fingerprinted a missing directory")
+	}
+}
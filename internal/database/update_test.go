@@ -0,0 +1,174 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupTestContent commits the files, by path, to a new repository set as the content repository updates clone.
+// Tailwind is replaced with a stub, see stubTailwind. Returns the repository directory.
+func setupTestContent(t *testing.T, contents map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	runTestGit(t, dir, "init", "-b", "main")
+	writeTestFiles(t, dir, contents)
+	commitTestFiles(t, dir)
+	if err := os.MkdirAll(filepath.Join("data", "css"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	stubTailwind(t, false)
+	utils.Config.ContentRepo.URL = dir
+	return dir
+}
+
+// commitTestFiles commits every change in the repository at dir.
+func commitTestFiles(t *testing.T, dir string) {
+	t.Helper()
+	runTestGit(t, dir, "add", "-A")
+	runTestGit(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "test")
+}
+
+// runTestGit runs git with the arguments in dir.
+func runTestGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, output)
+	}
+}
+
+// assertFile fails the test unless the file at path has the given content.
+func assertFile(t *testing.T, path, want string) {
+	t.Helper()
+	if got, err := os.ReadFile(path); err != nil || string(got) != want {
+		t.Errorf("%s = %q, %v, want %q", path, got, err, want)
+	}
+}
+
+// writeTestFiles writes the files, by slash separated path, to dir.
+func writeTestFiles(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+	for relPath, content := range contents {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// stubTailwind puts an npx on the path that writes an empty file at its -o argument, or fails if fail is set.
+func stubTailwind(t *testing.T, fail bool) {
+	t.Helper()
+	bin := t.TempDir()
+	stub := "#!/bin/sh\nwhile [ \"$1\" != \"-o\" ]; do shift; done\n: > \"$2\"\n"
+	if fail {
+		stub = "#!/bin/sh\nexit 1\n"
+	}
+	if err := os.WriteFile(filepath.Join(bin, "npx"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestUpdate(t *testing.T) {
+	setupTestDB(t)
+	dir := setupTestContent(t, map[string]string{
+		".github/ids.json": `{"aaa": "a.md", "bbb": "b.md"}`,
+		"a.md":             "<!-- ID: aaa -->\n# A\n[[bbb]]\n",
+		"b.md":             "<!-- ID: bbb -->\n---\ntitle: B Title\n---\nSearchable body\n",
+		"assets/logo.png":  "v1",
+		"assets/old.png":   "old",
+	})
+	if err := runUpdate(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// pages are rendered once all are loaded, so links show titles of pages loaded after them
+	content, err := GetContent("aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content.HTML, `<a href="/p/b">B Title</a>`) {
+		t.Errorf("html = %s", content.HTML)
+	}
+	if results, err := Search("searchable", 10); err != nil || len(results) != 1 || results[0].ID != "bbb" {
+		t.Errorf("Search() = %+v, %v", results, err)
+	}
+	assertFile(t, filepath.Join(ASSETS_PATH, "assets", "logo.png"), "v1")
+	for _, path := range []string{STAGED_ASSETS_PATH, STAGED_CSS_PATH} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(CSS_PATH); err != nil {
+		t.Error(err)
+	}
+
+	// the staged assets share unchanged files with the live ones, changed files are replaced rather than written through
+	live, err := os.Open(filepath.Join(ASSETS_PATH, "assets", "logo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	writeTestFiles(t, dir, map[string]string{
+		"b.md":            "<!-- ID: bbb -->\n---\ntitle: New Title\n---\nSearchable body\n",
+		"assets/logo.png": "v2",
+	})
+	if err := os.Remove(filepath.Join(dir, "assets", "old.png")); err != nil {
+		t.Fatal(err)
+	}
+	commitTestFiles(t, dir)
+	if err := runUpdate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if old, err := io.ReadAll(live); err != nil || string(old) != "v1" {
+		t.Errorf("live asset changed during the update: %q, %v", old, err)
+	}
+	assertFile(t, filepath.Join(ASSETS_PATH, "assets", "logo.png"), "v2")
+	if _, err := os.Stat(filepath.Join(ASSETS_PATH, "assets", "old.png")); !os.IsNotExist(err) {
+		t.Errorf("removed asset kept: %v", err)
+	}
+	if content, _ = GetContent("aaa"); !strings.Contains(content.HTML, `<a href="/p/b">New Title</a>`) {
+		t.Errorf("linking page not re-rendered: %s", content.HTML)
+	}
+}
+
+func TestFailedUpdate(t *testing.T) {
+	setupTestDB(t)
+	dir := setupTestContent(t, map[string]string{
+		".github/ids.json": `{"aaa": "a.md"}`,
+		"a.md":             "<!-- ID: aaa -->\n# Before\n",
+		"assets/logo.png":  "v1",
+	})
+	if err := runUpdate(nil); err != nil {
+		t.Fatal(err)
+	}
+	before, err := GetContent("aaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing changes if tailwind fails, the pages were rendered but not written
+	writeTestFiles(t, dir, map[string]string{"a.md": "<!-- ID: aaa -->\n# After\n", "assets/logo.png": "v2"})
+	commitTestFiles(t, dir)
+	stubTailwind(t, true)
+	if err := runUpdate(nil); err == nil {
+		t.Fatal("update succeeded")
+	}
+	if after, err := GetContent("aaa"); err != nil || after.HTML != before.HTML {
+		t.Errorf("content changed: %+v, %v", after, err)
+	}
+	assertFile(t, filepath.Join(ASSETS_PATH, "assets", "logo.png"), "v1")
+	if _, err := os.Stat(STAGED_ASSETS_PATH); !os.IsNotExist(err) {
+		t.Errorf("staged assets left behind: %v", err)
+	}
+}
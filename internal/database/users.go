@@ -0,0 +1,208 @@
+package database
+
+import (
+	"errors"
+	"intermark/internal/utils"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Roles, each includes the permissions of the ones before it.
+const (
+	ROLE_VIEWER = "viewer-preview" // can open the editor and preview drafts
+	ROLE_EDITOR = "editor"         // can use the sandbox, update content, and save the layout
+	ROLE_ADMIN  = "admin"          // can manage users
+)
+
+var roleRanks = map[string]int{ROLE_VIEWER: 1, ROLE_EDITOR: 2, ROLE_ADMIN: 3}
+
+// dummyHash is compared against for unknown usernames so they take as long to reject as wrong passwords.
+// It's a bcrypt hash at the default cost of a password nobody uses.
+const dummyHash = "$2a$10$PBJ8c78cF6B7ajIAuG2qy.bgjr.IHN9dM1ymiYU23/Bv16uArpp2i"
+
+type UserModel struct {
+	ID           uint      `json:"ID" gorm:"primaryKey"`
+	Username     string    `json:"Username" gorm:"uniqueIndex"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"Role"`
+	CreatedAt    time.Time `json:"CreatedAt"`
+}
+
+type SessionModel struct {
+	Token   string `gorm:"primaryKey"`
+	UserID  uint   `gorm:"index"`
+	Expires time.Time
+}
+
+// HasRole returns true if the given role includes the permissions of the required role.
+func HasRole(role, required string) bool {
+	return roleRanks[role] != 0 && roleRanks[role] >= roleRanks[required]
+}
+
+// initUsers creates an admin user with the password from the config if there are no users yet.
+// Nobody can log in until edit_password is set, it's never used as an empty password.
+func initUsers() error {
+	var count int64
+	if err := DB.Model(&UserModel{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count != 0 {
+		return nil
+	}
+	if utils.Config.EditPassword == "" {
+		blog.Error("No users found and edit_password is empty, set it in the config to create 'admin'")
+		return nil
+	}
+	blog.Info("No users found, creating 'admin' with the edit password from the config")
+	_, err := CreateUser("admin", utils.Config.EditPassword, ROLE_ADMIN)
+	return err
+}
+
+// CreateUser creates a new user with the given password and role.
+func CreateUser(username, password, role string) (*UserModel, error) {
+	if username == "" {
+		return nil, errors.New("username cannot be empty")
+	}
+	if password == "" {
+		return nil, errors.New("password cannot be empty")
+	}
+	if roleRanks[role] == 0 {
+		return nil, errors.New("invalid role")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := UserModel{Username: username, PasswordHash: string(hash), Role: role}
+	if err := DB.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser sets the role and, if not nil, the password of the user with the given id.
+// Changing the password or role ends all of the user's sessions.
+func UpdateUser(id uint, password *string, role string) error {
+	if roleRanks[role] == 0 {
+		return errors.New("invalid role")
+	}
+	if password != nil && *password == "" {
+		return errors.New("password cannot be empty")
+	}
+	updates := map[string]interface{}{"role": role}
+	if password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		updates["password_hash"] = string(hash)
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := lastAdminCheck(tx, id, role); err != nil {
+			return err
+		}
+		if err := tx.Model(&UserModel{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&SessionModel{}).Error
+	})
+}
+
+// DeleteUser deletes the user with the given id and all of their sessions.
+func DeleteUser(id uint) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := lastAdminCheck(tx, id, ""); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&SessionModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&UserModel{}, id).Error
+	})
+}
+
+// GetUsers retrieves all users.
+func GetUsers() ([]UserModel, error) {
+	var users []UserModel
+	if err := DB.Order("username").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Authenticate returns the user if the username and password match, or nil if they don't.
+func Authenticate(username, password string) (*UserModel, error) {
+	var user UserModel
+	if err := DB.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+			return nil, nil
+		}
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// CreateSession creates a new session for the user and returns its token. Expired sessions are cleaned up as well.
+func CreateSession(userID uint, maxAge time.Duration) (string, error) {
+	if err := DB.Where("expires < ?", time.Now()).Delete(&SessionModel{}).Error; err != nil {
+		return "", err
+	}
+	token, err := utils.GenRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	if err := DB.Create(&SessionModel{Token: token, UserID: userID, Expires: time.Now().Add(maxAge)}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetSessionUser returns the user for the given session token, or nil if the session doesn't exist or has expired.
+func GetSessionUser(token string) (*UserModel, error) {
+	if token == "" {
+		return nil, nil
+	}
+	var session SessionModel
+	if err := DB.Where("token = ? AND expires > ?", token, time.Now()).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var user UserModel
+	if err := DB.First(&user, session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteSession ends the session with the given token.
+func DeleteSession(token string) error {
+	return DB.Where("token = ?", token).Delete(&SessionModel{}).Error
+}
+
+// lastAdminCheck returns an error if the change would leave the site without an admin.
+// newRole is empty for deletions.
+func lastAdminCheck(tx *gorm.DB, id uint, newRole string) error {
+	if newRole == ROLE_ADMIN {
+		return nil
+	}
+	var admins int64
+	if err := tx.Model(&UserModel{}).Where("role = ? AND id <> ?", ROLE_ADMIN, id).Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins == 0 {
+		return errors.New("there must be at least one admin")
+	}
+	return nil
+}
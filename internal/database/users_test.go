@@ -0,0 +1,151 @@
+package database
+
+import (
+	"intermark/internal/utils"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{ROLE_ADMIN, ROLE_VIEWER, true},
+		{ROLE_ADMIN, ROLE_ADMIN, true},
+		{ROLE_EDITOR, ROLE_EDITOR, true},
+		{ROLE_EDITOR, ROLE_ADMIN, false},
+		{ROLE_VIEWER, ROLE_EDITOR, false},
+		{"", ROLE_VIEWER, false},
+		{"unknown", "unknown", false},
+	}
+	for _, test := range tests {
+		if got := HasRole(test.role, test.required); got != test.want {
+			t.Errorf("HasRole(%q, %q) = %v, want %v", test.role, test.required, got, test.want)
+		}
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	// unknown usernames must cost as much as real ones to reject
+	if cost, err := bcrypt.Cost([]byte(dummyHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost = %d, %v, want %d", cost, err, bcrypt.DefaultCost)
+	}
+}
+
+func TestInitUsers(t *testing.T) {
+	setupTestDB(t)
+	if user, err := Authenticate("admin", "password"); err != nil || user == nil || user.Role != ROLE_ADMIN {
+		t.Fatalf("admin not created from edit_password: %+v, %v", user, err)
+	}
+
+	// no admin is seeded with an empty password
+	if err := DB.Exec("DELETE FROM user_models").Error; err != nil {
+		t.Fatal(err)
+	}
+	utils.Config.EditPassword = ""
+	if err := initUsers(); err != nil {
+		t.Fatal(err)
+	}
+	if users, err := GetUsers(); err != nil || len(users) != 0 {
+		t.Errorf("users = %+v, %v", users, err)
+	}
+	if user, err := Authenticate("admin", ""); err != nil || user != nil {
+		t.Errorf("Authenticate(admin, '') = %+v, %v", user, err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	setupTestDB(t)
+	for _, args := range [][3]string{{"", "pw", ROLE_EDITOR}, {"bob", "", ROLE_EDITOR}, {"bob", "pw", "owner"}} {
+		if _, err := CreateUser(args[0], args[1], args[2]); err == nil {
+			t.Errorf("CreateUser(%q, %q, %q) succeeded", args[0], args[1], args[2])
+		}
+	}
+	bob, err := CreateUser("bob", "secret", ROLE_EDITOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateUser("bob", "other", ROLE_EDITOR); err == nil {
+		t.Error("duplicate username accepted")
+	}
+	if user, _ := Authenticate("bob", "wrong"); user != nil {
+		t.Error("wrong password accepted")
+	}
+	if user, _ := Authenticate("nobody", "secret"); user != nil {
+		t.Error("unknown user accepted")
+	}
+
+	// sessions end when the user changes
+	token, err := CreateSession(bob.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := GetSessionUser(token); err != nil || user == nil || user.Username != "bob" {
+		t.Fatalf("GetSessionUser() = %+v, %v", user, err)
+	}
+	empty, password := "", "new"
+	if err := UpdateUser(bob.ID, &empty, ROLE_EDITOR); err == nil {
+		t.Error("empty password accepted")
+	}
+	if user, _ := GetSessionUser(token); user == nil {
+		t.Error("rejected update ended the session")
+	}
+	if err := UpdateUser(bob.ID, nil, ROLE_VIEWER); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := GetSessionUser(token); user != nil {
+		t.Error("session survived a role change")
+	}
+	if user, _ := Authenticate("bob", "secret"); user == nil || user.Role != ROLE_VIEWER {
+		t.Errorf("role change altered the password or didn't apply: %+v", user)
+	}
+	if err := UpdateUser(bob.ID, &password, ROLE_VIEWER); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := Authenticate("bob", "new"); user == nil {
+		t.Error("password not changed")
+	}
+
+	// expired and deleted sessions
+	expired, err := CreateSession(bob.ID, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := GetSessionUser(expired); user != nil {
+		t.Error("expired session accepted")
+	}
+	token, err = CreateSession(bob.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteSession(token); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := GetSessionUser(token); user != nil {
+		t.Error("deleted session accepted")
+	}
+
+	// the last admin can't be demoted or deleted
+	admin, err := Authenticate("admin", "password")
+	if err != nil || admin == nil {
+		t.Fatal(err)
+	}
+	if err := UpdateUser(admin.ID, nil, ROLE_EDITOR); err == nil {
+		t.Error("last admin demoted")
+	}
+	if err := DeleteUser(admin.ID); err == nil {
+		t.Error("last admin deleted")
+	}
+	if err := UpdateUser(bob.ID, nil, ROLE_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteUser(admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if users, err := GetUsers(); err != nil || len(users) != 1 || users[0].Username != "bob" {
+		t.Errorf("users = %+v, %v", users, err)
+	}
+}
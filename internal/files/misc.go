@@ -111,6 +111,34 @@ func CopyDir(src, dst string) error {
 	})
 }
 
+// LinkDir recreates the directory at `src` at `dst` with hard links to its files, copying those that can't be linked.
+// If `dst` exists, it is deleted and replaced. The files are shared, remove one from `dst` before writing to it.
+func LinkDir(src, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(dstPath, os.ModePerm)
+		}
+		if err := os.Link(path, dstPath); err != nil {
+			return CopyFile(path, dstPath)
+		}
+		return nil
+	})
+}
+
 func ReadFile(path string) (string, error) {
 	if content, err := os.ReadFile(path); err != nil {
 		return "", err
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the optional metadata block at the top of a page, after the ID line.
+// YAML is fenced by "---" lines, TOML by "+++" lines.
+type FrontMatter struct {
+	Title       string    `json:"Title" yaml:"title" toml:"title"`
+	Description string    `json:"Description" yaml:"description" toml:"description"`
+	Tags        []string  `json:"Tags" yaml:"tags" toml:"tags" gorm:"serializer:json"`
+	Author      string    `json:"Author" yaml:"author" toml:"author"`
+	Date        time.Time `json:"Date" yaml:"date" toml:"date"`
+	Draft       bool      `json:"Draft" yaml:"draft" toml:"draft"`
+	Publish     time.Time `json:"Publish" yaml:"publish" toml:"publish"`    // hidden until this time if set
+	Template    string    `json:"Template" yaml:"template" toml:"template"` // name of the page template, without ".html"
+	Slug        string    `json:"Slug" yaml:"slug" toml:"slug"`             // readable path of the page instead of one from its file path
+}
+
+// SplitFrontMatter parses the front matter at the start of the markdown, if any.
+// Returns the front matter and the markdown with the block removed. On error the markdown is returned unchanged.
+func SplitFrontMatter(markdown string) (FrontMatter, string, error) {
+	var fm FrontMatter
+	// skip leading blank lines
+	body := strings.TrimLeft(markdown, "\r\n")
+	var fence string
+	switch {
+	case strings.HasPrefix(body, "---"):
+		fence = "---"
+	case strings.HasPrefix(body, "+++"):
+		fence = "+++"
+	default:
+		return fm, markdown, nil
+	}
+	// the fence must be alone on its line
+	lines := strings.SplitAfter(body, "\n")
+	if strings.TrimSpace(lines[0]) != fence {
+		return fm, markdown, nil
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == fence {
+			end = i
+			break
+		}
+	}
+	if end == -1 {
+		return fm, markdown, nil // just a thematic break
+	}
+	block := strings.Join(lines[1:end], "")
+	var err error
+	if fence == "---" {
+		err = yaml.Unmarshal([]byte(block), &fm)
+	} else {
+		_, err = toml.Decode(block, &fm)
+	}
+	if err != nil {
+		return FrontMatter{}, markdown, fmt.Errorf("invalid front matter: %w", err)
+	}
+	return fm, strings.Join(lines[end+1:], ""), nil
+}
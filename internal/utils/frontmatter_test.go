@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		markdown string
+		want     FrontMatter
+		body     string
+		err      bool
+	}{
+		{"none", "# Title\n", FrontMatter{}, "# Title\n", false},
+		{
+			"yaml",
+			"---\ntitle: Hello\ntags: [a, b]\ndate: 2024-03-01\ndraft: true\ntemplate: wide\n---\n# Body\n",
+			FrontMatter{Title: "Hello", Tags: []string{"a", "b"}, Date: date, Draft: true, Template: "wide"},
+			"# Body\n", false,
+		},
+		{
+			"toml",
+			"+++\ntitle = \"Hello\"\nauthor = \"Ann\"\ndate = 2024-03-01T00:00:00Z\nslug = \"hi\"\n+++\nBody",
+			FrontMatter{Title: "Hello", Author: "Ann", Date: date, Slug: "hi"},
+			"Body", false,
+		},
+		{"crlf and leading blank lines", "\r\n---\r\ndescription: d\r\n---\r\nBody\r\n", FrontMatter{Description: "d"}, "Body\r\n", false},
+		{"empty block", "---\n---\nBody", FrontMatter{}, "Body", false},
+		{"unknown keys are ignored", "---\ncolor: red\n---\n", FrontMatter{}, "", false},
+		{"thematic break", "---\n# Not front matter\n", FrontMatter{}, "---\n# Not front matter\n", false},
+		{"fence not alone", "--- title\nx\n---\n", FrontMatter{}, "--- title\nx\n---\n", false},
+		{"later block", "# Title\n---\ntitle: x\n---\n", FrontMatter{}, "# Title\n---\ntitle: x\n---\n", false},
+		{"invalid yaml", "---\ntitle: [oops\n---\nBody", FrontMatter{}, "---\ntitle: [oops\n---\nBody", true},
+		{"invalid toml", "+++\ntitle = \n+++\nBody", FrontMatter{}, "+++\ntitle = \n+++\nBody", true},
+		{"wrong type", "---\ndraft: maybe\n---\n", FrontMatter{}, "---\ndraft: maybe\n---\n", true},
+	}
+	for _, test := range tests {
+		front, body, err := SplitFrontMatter(test.markdown)
+		if (err != nil) != test.err {
+			t.Errorf("%s: err = %v", test.name, err)
+		}
+		if !reflect.DeepEqual(front, test.want) || body != test.body {
+			t.Errorf("%s: got %+v, %q, want %+v, %q", test.name, front, body, test.want, test.body)
+		}
+	}
+}
@@ -1,15 +1,90 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Data-Corruption/blog"
 )
 
+// git backends
+const (
+	GIT_BACKEND_EXEC  = "exec"   // runs the git command, requires git on PATH
+	GIT_BACKEND_GOGIT = "go-git" // runs in process
+)
+
+// GitBackend runs the git operations used to sync the content repository.
+type GitBackend interface {
+	// Clone clones the branch of the repository into repoPath and returns the commit hash of HEAD.
+	Clone(repoURL, repoPath, branch string) (string, error)
+	// Reset fetches and hard resets to ref, or to the latest commit on the branch if ref is empty. Returns the new HEAD.
+	Reset(repoPath, branch, ref string) (string, error)
+	// Resolve fetches the branch and tags and returns the commit hash ref points to.
+	Resolve(repoPath, branch, ref string) (string, error)
+	CommitHash(repoPath string) (string, error)
+	CommitTime(repoPath, commitHash string) (time.Time, error)
+	// ChangedFiles returns the slash separated paths of every file added, modified, or deleted between two commits.
+	ChangedFiles(repoPath, from, to string) ([]string, error)
+}
+
+var gitBackend GitBackend = execGit{}
+
+// SetGitBackend selects the git backend by name, empty selects the exec backend.
+func SetGitBackend(name string) error {
+	switch name {
+	case "", GIT_BACKEND_EXEC:
+		gitBackend = execGit{}
+	case GIT_BACKEND_GOGIT:
+		gitBackend = goGit{}
+	default:
+		return fmt.Errorf("unknown git backend '%s'", name)
+	}
+	blog.Infof("Using the %s git backend", gitBackend)
+	return nil
+}
+
+func GitClone(repoURL, repoPath, branch string) (string, error) {
+	return gitBackend.Clone(repoURL, repoPath, branch)
+}
+
+// GitReset resets the repository to ref, or to the latest commit on the branch if ref is empty,
+// and returns the commit hash. Ref can be a commit hash or tag, see ValidGitRef.
+func GitReset(repoPath, branch, ref string) (string, error) {
+	return gitBackend.Reset(repoPath, branch, ref)
+}
+
+// GitResolve fetches the repository and returns the commit hash ref points to.
+func GitResolve(repoPath, branch, ref string) (string, error) {
+	return gitBackend.Resolve(repoPath, branch, ref)
+}
+
+func GitCommitHash(repoPath string) (string, error) {
+	return gitBackend.CommitHash(repoPath)
+}
+
+// GitCommitTime returns the committer date of the given commit.
+func GitCommitTime(repoPath, commitHash string) (time.Time, error) {
+	return gitBackend.CommitTime(repoPath, commitHash)
+}
+
+// GitChangedFiles returns the paths of every file added, modified, or deleted between the two commits in one pass.
+func GitChangedFiles(repoPath, from, to string) ([]string, error) {
+	return gitBackend.ChangedFiles(repoPath, from, to)
+}
+
+// ValidGitRef returns true if ref looks like a commit hash or tag name that is safe to pass to git.
+func ValidGitRef(ref string) bool {
+	return gitRefRegex.MatchString(ref) && !strings.Contains(ref, "..")
+}
+
+var gitRefRegex = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._/-]*$`)
+
 func GitInstalled() bool {
 	cmd := exec.Command("git", "--version")
 	if err := cmd.Run(); err != nil {
@@ -21,70 +96,49 @@ func GitInstalled() bool {
 	}
 }
 
-// GitFileDiff checks if the given file has changed since the given commit.
-// It returns true if the file has changed, false if not, and an error if any.
-// If commitHash is empty, it returns true.
-func GitFileDiff(repoPath, filePath, commitHash string) (bool, error) {
-	if commitHash == "" {
-		return true, nil
-	}
+// execGit is the GitBackend that runs the git command.
+type execGit struct{}
 
-	// Validate input parameters and check if the paths exist
-	if repoPath == "" || filePath == "" {
-		return false, fmt.Errorf("repository path and file path cannot be empty")
-	}
-	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		return false, fmt.Errorf("repository path does not exist: %w", err)
-	}
-	if _, err := os.Stat(filepath.Join(repoPath, filePath)); os.IsNotExist(err) {
-		return false, fmt.Errorf("file does not exist: %w", err)
-	}
+func (execGit) String() string { return GIT_BACKEND_EXEC }
 
-	// Prepare and run the git diff command, capturing output
-	cmd := exec.Command("git", "diff", "--exit-code", commitHash, "--", filePath)
+func (execGit) CommitHash(repoPath string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
 	cmd.Dir = repoPath
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		// If the error is an exit error and the exit code is 1, the file has changed. Otherwise, return the error
-		if exitError, ok := err.(*exec.ExitError); ok {
-			if exitError.ExitCode() == 1 {
-				return true, nil
-			}
-		}
-		return false, fmt.Errorf("error running git diff: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+		return "", fmt.Errorf("error getting commit hash: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
 	}
-	return false, nil
+	return strings.TrimSpace(string(output)), nil
 }
 
-func GitCommitHash(repoPath string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", "HEAD")
+func (execGit) CommitTime(repoPath, commitHash string) (time.Time, error) {
+	cmd := exec.Command("git", "show", "-s", "--format=%cI", commitHash)
 	cmd.Dir = repoPath
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("error getting commit hash: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+		return time.Time{}, fmt.Errorf("error getting commit time: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
 	}
-	return strings.TrimSpace(string(output)), nil
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(output)))
 }
 
-func GitClone(repoURL, repoPath string) (string, error) {
+func (g execGit) Clone(repoURL, repoPath, branch string) (string, error) {
 	if err := os.MkdirAll(repoPath, os.ModePerm); err != nil {
 		return "", fmt.Errorf("error creating repository path: %w", err)
 	}
-	// modify the repoURL to use the configured SSH host if it contains 'github.com'
-	repoURL = strings.Replace(repoURL, "github.com", Config.ContentRepo.SshHost, 1)
-	// clone the repo
-	cmd := exec.Command("git", "clone", repoURL, ".")
-	cmd.Dir = repoPath
+	// clone the repo, using the configured SSH host alias for ssh urls, see cloneURL
+	cmd, err := gitCommand(repoPath, "clone", "--branch", branch, cloneURL(repoURL), ".")
+	if err != nil {
+		return "", err
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("error running git clone: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
 	}
 	blog.Debugf("Clone output: %s", strings.TrimSpace(string(output)))
-	return GitCommitHash(repoPath)
+	return g.CommitHash(repoPath)
 }
 
-// GitReset resets the repository to the latest commit on the branch set in the config and returns the commit hash.
-func GitReset(repoPath string) (string, error) {
+func (g execGit) Reset(repoPath, branch, ref string) (string, error) {
 	if err := os.MkdirAll(repoPath, os.ModePerm); err != nil {
 		return "", fmt.Errorf("error creating repository path: %w", err)
 	}
@@ -92,20 +146,65 @@ func GitReset(repoPath string) (string, error) {
 		return "", fmt.Errorf("repository path does not contain a .git directory")
 	}
 
-	cmd := exec.Command("git", "fetch", "origin", Config.ContentRepo.Branch)
+	if err := gitFetch(repoPath, branch); err != nil {
+		return "", err
+	}
+
+	target := "origin/" + branch
+	if ref != "" {
+		target = ref + "^{commit}"
+	}
+	cmd := exec.Command("git", "reset", "--hard", target)
 	cmd.Dir = repoPath
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("error running git fetch origin %s: %w\nOutput: %s", Config.ContentRepo.Branch, err, strings.TrimSpace(string(output)))
+		return "", fmt.Errorf("error running git reset --hard %s: %w\nOutput: %s", target, err, strings.TrimSpace(string(output)))
 	}
-	blog.Debugf("Fetch output: %s", strings.TrimSpace(string(output)))
+	blog.Debugf("Reset output: %s", strings.TrimSpace(string(output)))
+	return g.CommitHash(repoPath)
+}
 
-	cmd = exec.Command("git", "reset", "--hard", "origin/"+Config.ContentRepo.Branch)
+func (execGit) Resolve(repoPath, branch, ref string) (string, error) {
+	if err := gitFetch(repoPath, branch); err != nil {
+		return "", err
+	}
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
 	cmd.Dir = repoPath
-	output, err = cmd.CombinedOutput()
+	output, err := cmd.Output()
 	if err != nil {
-		return "", fmt.Errorf("error running git reset --hard origin/%s: %w\nOutput: %s", Config.ContentRepo.Branch, err, strings.TrimSpace(string(output)))
+		return "", fmt.Errorf("unknown commit or tag '%s'", ref)
 	}
-	blog.Debugf("Reset output: %s", strings.TrimSpace(string(output)))
-	return GitCommitHash(repoPath)
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (execGit) ChangedFiles(repoPath, from, to string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", "--no-renames", "-z", from, to, "--")
+	cmd.Dir = repoPath
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running git diff %s %s: %w\nOutput: %s", from, to, err, strings.TrimSpace(stderr.String()))
+	}
+	var changed []string
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			changed = append(changed, path)
+		}
+	}
+	return changed, nil
+}
+
+// gitFetch fetches the branch and all tags.
+func gitFetch(repoPath, branch string) error {
+	cmd, err := gitCommand(repoPath, "fetch", "--force", "--tags", "origin", branch)
+	if err != nil {
+		return err
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running git fetch origin %s: %w\nOutput: %s", branch, err, strings.TrimSpace(string(output)))
+	}
+	blog.Debugf("Fetch output: %s", strings.TrimSpace(string(output)))
+	return nil
 }
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidGitRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{"v1.2.0", true},
+		{"release/2024-01", true},
+		{"3f2a9c1", true},
+		{"", false},
+		{"-v1", false}, // would be read as an option
+		{".hidden", false},
+		{"v1..v2", false},
+		{"main~1", false},
+		{"tag name", false},
+		{"$(whoami)", false},
+	}
+	for _, test := range tests {
+		if got := ValidGitRef(test.ref); got != test.want {
+			t.Errorf("ValidGitRef(%q) = %v, want %v", test.ref, got, test.want)
+		}
+	}
+}
+
+// runGit runs git in dir and returns its trimmed output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// commitFiles writes the files, by slash separated path, to the repository and commits everything. Returns the commit hash.
+func commitFiles(t *testing.T, repo string, contents map[string]string) string {
+	t.Helper()
+	for relPath, content := range contents {
+		path := filepath.Join(repo, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, repo, "add", "-A")
+	runGit(t, repo, "commit", "-m", "update")
+	return runGit(t, repo, "rev-parse", "HEAD")
+}
+
+func TestGitBackends(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	for _, backend := range []string{GIT_BACKEND_EXEC, GIT_BACKEND_GOGIT} {
+		t.Run(backend, func(t *testing.T) {
+			if err := SetGitBackend(backend); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { SetGitBackend("") })
+
+			origin := t.TempDir()
+			runGit(t, origin, "init", "-b", "main")
+			first := commitFiles(t, origin, map[string]string{"a.md": "a", "b.md": "b"})
+			runGit(t, origin, "tag", "-a", "v1", "-m", "first release") // annotated tags resolve to their commit
+
+			clone := filepath.Join(t.TempDir(), "clone")
+			head, err := GitClone(origin, clone, "main")
+			if err != nil || head != first {
+				t.Fatalf("GitClone() = %s, %v, want %s", head, err, first)
+			}
+			if when, err := GitCommitTime(clone, head); err != nil || time.Since(when) > time.Minute {
+				t.Errorf("GitCommitTime() = %s, %v", when, err)
+			}
+
+			// new commits are fetched on reset, pins are resolved against the fetched tags
+			runGit(t, origin, "rm", "-q", "a.md")
+			second := commitFiles(t, origin, map[string]string{"b.md": "changed", "c/d.md": "d"})
+			if hash, err := GitResolve(clone, "main", "v1"); err != nil || hash != first {
+				t.Errorf("GitResolve(v1) = %s, %v", hash, err)
+			}
+			if _, err := GitResolve(clone, "main", "v2"); err == nil {
+				t.Error("resolved an unknown tag")
+			}
+			if head, err = GitReset(clone, "main", ""); err != nil || head != second {
+				t.Fatalf("GitReset() = %s, %v, want %s", head, err, second)
+			}
+			if _, err := os.Stat(filepath.Join(clone, "c", "d.md")); err != nil {
+				t.Error(err)
+			}
+			changed, err := GitChangedFiles(clone, first, second)
+			sort.Strings(changed)
+			if want := []string{"a.md", "b.md", "c/d.md"}; err != nil || !reflect.DeepEqual(changed, want) {
+				t.Errorf("GitChangedFiles() = %v, %v, want %v", changed, err, want)
+			}
+
+			// pinning goes back to an older commit
+			if head, err = GitReset(clone, "main", "v1"); err != nil || head != first {
+				t.Fatalf("GitReset(v1) = %s, %v, want %s", head, err, first)
+			}
+			if hash, err := GitCommitHash(clone); err != nil || hash != first {
+				t.Errorf("GitCommitHash() = %s, %v", hash, err)
+			}
+			if _, err := os.Stat(filepath.Join(clone, "a.md")); err != nil {
+				t.Error(err)
+			}
+			if _, err := GitReset(clone, "main", "v2"); err == nil {
+				t.Error("reset to an unknown tag")
+			}
+		})
+	}
+}
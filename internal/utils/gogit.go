@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/kevinburke/ssh_config"
+)
+
+// goGit is the GitBackend that runs in process with go-git, git doesn't need to be installed.
+type goGit struct{}
+
+func (goGit) String() string { return GIT_BACKEND_GOGIT }
+
+func (g goGit) Clone(repoURL, repoPath, branch string) (string, error) {
+	if err := os.MkdirAll(repoPath, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating repository path: %w", err)
+	}
+	repoURL = cloneURL(repoURL)
+	auth, err := goGitAuth(repoURL)
+	if err != nil {
+		return "", err
+	}
+	_, err = git.PlainClone(repoPath, false, &git.CloneOptions{
+		URL:           repoURL,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		Tags:          git.AllTags,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error cloning %s: %w", repoURL, err)
+	}
+	return g.CommitHash(repoPath)
+}
+
+func (goGit) Reset(repoPath, branch, ref string) (string, error) {
+	repo, err := goGitFetch(repoPath, branch)
+	if err != nil {
+		return "", err
+	}
+	var target plumbing.Hash
+	if ref == "" {
+		remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+		if err != nil {
+			return "", fmt.Errorf("error finding origin/%s: %w", branch, err)
+		}
+		target = remoteRef.Hash()
+	} else if target, err = goGitResolve(repo, ref); err != nil {
+		return "", err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("error opening worktree: %w", err)
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: target, Mode: git.HardReset}); err != nil {
+		return "", fmt.Errorf("error resetting to %s: %w", target, err)
+	}
+	blog.Debugf("Reset to %s", target)
+	return target.String(), nil
+}
+
+func (goGit) Resolve(repoPath, branch, ref string) (string, error) {
+	repo, err := goGitFetch(repoPath, branch)
+	if err != nil {
+		return "", err
+	}
+	hash, err := goGitResolve(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func (goGit) CommitHash(repoPath string) (string, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("error opening repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("error getting commit hash: %w", err)
+	}
+	return head.Hash().String(), nil
+}
+
+func (goGit) CommitTime(repoPath, commitHash string) (time.Time, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error opening repository: %w", err)
+	}
+	hash, err := goGitResolve(repo, commitHash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting commit time: %w", err)
+	}
+	return commit.Committer.When, nil
+}
+
+func (goGit) ChangedFiles(repoPath, from, to string) ([]string, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+	fromTree, err := goGitTree(repo, from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := goGitTree(repo, to)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("error diffing %s %s: %w", from, to, err)
+	}
+	var changed []string
+	seen := make(map[string]bool)
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				changed = append(changed, name)
+			}
+		}
+	}
+	return changed, nil
+}
+
+// goGitFetch opens the repository and fetches the branch and all tags.
+func goGitFetch(repoPath, branch string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return nil, fmt.Errorf("error getting origin: %w", err)
+	}
+	auth, err := goGitAuth(remote.Config().URLs[0])
+	if err != nil {
+		return nil, err
+	}
+	err = remote.Fetch(&git.FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+refs/heads/" + branch + ":refs/remotes/origin/" + branch),
+			"+refs/tags/*:refs/tags/*",
+		},
+		Auth:  auth,
+		Force: true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, fmt.Errorf("error fetching origin %s: %w", branch, err)
+	}
+	return repo, nil
+}
+
+// goGitResolve returns the commit hash ref points to, peeling annotated tags.
+func goGitResolve(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unknown commit or tag '%s'", ref)
+	}
+	return *hash, nil
+}
+
+// goGitTree returns the tree of the commit ref points to.
+func goGitTree(repo *git.Repository, ref string) (*object.Tree, error) {
+	hash, err := goGitResolve(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error reading commit %s: %w", hash, err)
+	}
+	return commit.Tree()
+}
+
+// goGitAuth returns the configured login for https urls, and for ssh urls the configured key or the one set as
+// IdentityFile for the url's host in ~/.ssh/config. Nil falls back to the ssh agent, or no auth for https urls.
+func goGitAuth(repoURL string) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing repository url: %w", err)
+	}
+	if endpoint.Protocol == "http" || endpoint.Protocol == "https" {
+		username, password, found, err := gitCredentials(repoURL)
+		if err != nil || !found {
+			return nil, err
+		}
+		return &githttp.BasicAuth{Username: username, Password: password}, nil
+	} else if endpoint.Protocol != "ssh" {
+		return nil, nil
+	}
+	keyPath, err := expandHome(Config.ContentRepo.SshKey)
+	if err != nil {
+		return nil, err
+	}
+	if keyPath == "" {
+		keyPath = ssh_config.Get(endpoint.Host, "IdentityFile")
+		if keyPath == "" || keyPath == ssh_config.Default("IdentityFile") {
+			return nil, nil
+		}
+		if keyPath, err = expandHome(keyPath); err != nil {
+			return nil, err
+		}
+	}
+	user := endpoint.User
+	if user == "" {
+		user = "git"
+	}
+	keys, err := gitssh.NewPublicKeysFromFile(user, keyPath, "")
+	if err != nil {
+		return nil, fmt.Errorf("error loading ssh key %s: %w", keyPath, err)
+	}
+	return keys, nil
+}
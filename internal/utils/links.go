@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const ID_LINK_PREFIX = "id:" // e.g. [text](id:TOKEN) or [text](id:TOKEN#heading)
+
+// LinkResolver returns the current url and title of the page with the given id, ok is false if it doesn't exist.
+type LinkResolver func(id string) (url, title string, ok bool)
+
+var linkResolverKey = parser.NewContextKey()
+
+// wikiLinkParser parses [[TOKEN]] and [[TOKEN|text]] into links to "id:TOKEN".
+type wikiLinkParser struct{}
+
+func (p *wikiLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (p *wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	if len(line) < 5 || line[1] != '[' {
+		return nil
+	}
+	end := bytes.Index(line, []byte("]]"))
+	if end < 3 {
+		return nil
+	}
+	inner := line[2:end]
+	if bytes.ContainsAny(inner, "[]") {
+		return nil
+	}
+	token, label, hasLabel := bytes.Cut(inner, []byte("|"))
+	token = bytes.TrimSpace(token)
+	if len(token) == 0 || bytes.ContainsAny(token, " \t") {
+		return nil
+	}
+	link := ast.NewLink()
+	link.Destination = append([]byte(ID_LINK_PREFIX), token...)
+	if hasLabel {
+		start := segment.Start + end - len(label)
+		labelSegment := text.NewSegment(start, segment.Start+end)
+		labelSegment = labelSegment.TrimLeftSpace(block.Source())
+		labelSegment = labelSegment.TrimRightSpace(block.Source())
+		if !labelSegment.IsEmpty() {
+			link.AppendChild(link, ast.NewTextSegment(labelSegment))
+		}
+	}
+	block.Advance(end + 2)
+	return link
+}
+
+// idLinkTransformer points links to "id:TOKEN" at the current url of the page, using the resolver in the parser context.
+// Links without text get the title of the page. Unresolved links are given the "broken-link" class.
+type idLinkTransformer struct{}
+
+func (t *idLinkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	resolve, _ := pc.Get(linkResolverKey).(LinkResolver)
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := node.(*ast.Link)
+		if !entering || !ok || !bytes.HasPrefix(link.Destination, []byte(ID_LINK_PREFIX)) {
+			return ast.WalkContinue, nil
+		}
+		id, fragment, _ := strings.Cut(string(link.Destination[len(ID_LINK_PREFIX):]), "#")
+		var url, title string
+		found := false
+		if resolve != nil {
+			url, title, found = resolve(id)
+		}
+		if !found {
+			url, title = "/page?id="+id, id
+			link.SetAttributeString("class", []byte("broken-link"))
+		}
+		if fragment != "" {
+			url += "#" + fragment
+		}
+		link.Destination = []byte(url)
+		if link.ChildCount() == 0 {
+			link.AppendChild(link, ast.NewString([]byte(title)))
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
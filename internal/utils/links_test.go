@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIDLinks(t *testing.T) {
+	pages := map[string][2]string{"aaa": {"/p/setup", "Setup Guide"}, "blog:bbb": {"/blog/p/post", "Post"}}
+	var resolved []string
+	resolve := func(id string) (string, string, bool) {
+		resolved = append(resolved, id)
+		page, ok := pages[id]
+		return page[0], page[1], ok
+	}
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"[[aaa]]", `<a href="/p/setup">Setup Guide</a>`},
+		{"[[aaa|the guide]]", `<a href="/p/setup">the guide</a>`},
+		{"[[ aaa | spaced ]]", `<a href="/p/setup">spaced</a>`},
+		{"[[aaa#install]]", `<a href="/p/setup#install">Setup Guide</a>`},
+		{"[[blog:bbb]]", `<a href="/blog/p/post">Post</a>`},
+		{"[text](id:aaa)", `<a href="/p/setup">text</a>`},
+		{"[](id:aaa#faq)", `<a href="/p/setup#faq">Setup Guide</a>`},
+		{"[[missing]]", `<a href="/page?id=missing" class="broken-link">missing</a>`},
+		{"[x](id:missing#top)", `<a href="/page?id=missing#top" class="broken-link">x</a>`},
+		{"before [[aaa]] after", `before <a href="/p/setup">Setup Guide</a> after`},
+		{"[[two words]]", `[[two words]]`},
+		{"[[]]", `[[]]`},
+		{"[[a[b]]", `[[a[b]]`},
+		{"[[aaa]", `[[aaa]`},
+		{"`[[aaa]]`", `<code>[[aaa]]</code>`},
+		{"[normal](/p/x)", `<a href="/p/x">normal</a>`},
+	}
+	for _, test := range tests {
+		html, _, err := MdToHTML(test.markdown, resolve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(html), "<p>"), "</p>")
+		if got != test.want {
+			t.Errorf("MdToHTML(%q) = %s, want %s", test.markdown, got, test.want)
+		}
+	}
+
+	// without a resolver every id link is broken
+	html, _, err := MdToHTML("[[aaa]]", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, `<a href="/page?id=aaa" class="broken-link">aaa</a>`) {
+		t.Errorf("MdToHTML(nil resolver) = %s", html)
+	}
+	if len(resolved) == 0 || resolved[0] != "aaa" {
+		t.Errorf("resolved = %v", resolved)
+	}
+}
@@ -2,21 +2,34 @@ package utils
 
 import (
 	"bytes"
+	"strings"
 
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
 )
 
 var md goldmark.Markdown
 
+// TOCEntry is a heading of a page, nested under the closest heading above it with a lower level.
+type TOCEntry struct {
+	Level    int        `json:"Level"`
+	ID       string     `json:"ID"` // anchor of the heading, e.g. "getting-started"
+	Title    string     `json:"Title"`
+	Children []TOCEntry `json:"Children"`
+}
+
 func InitMarkdownConverter() {
 	md = goldmark.New(
 		goldmark.WithExtensions(extension.GFM),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
-			parser.WithInlineParsers(),
+			parser.WithInlineParsers(util.Prioritized(&wikiLinkParser{}, 199)), // before the default link parser
+			parser.WithASTTransformers(util.Prioritized(&idLinkTransformer{}, 100)),
 		),
 		goldmark.WithRendererOptions(
 			html.WithHardWraps(),
@@ -26,7 +39,10 @@ func InitMarkdownConverter() {
 	)
 }
 
-func MdToHTML(markdown string) (string, error) {
+// MdToHTML converts the markdown to html, markdown inside <mdsrc> tags is converted first.
+// Links to page ids are resolved with resolve, which may be nil to leave them all unresolved.
+// Also returns the table of contents built from the headings of the page, not counting ones inside <mdsrc> tags.
+func MdToHTML(markdown string, resolve LinkResolver) (string, []TOCEntry, error) {
 	out := markdown
 	var mdsrc string
 	var index int
@@ -39,20 +55,80 @@ func MdToHTML(markdown string) (string, error) {
 		// strip opening and closing tags
 		mdsrc = mdsrc[len("<mdsrc>") : len(mdsrc)-len("</mdsrc>")]
 		// convert to html
-		var buf bytes.Buffer
-		if err := md.Convert([]byte(mdsrc), &buf); err != nil {
-			return "", err
+		converted, _, err := convert([]byte(mdsrc), resolve)
+		if err != nil {
+			return "", nil, err
 		}
 		// insert at index
-		out = out[:index] + buf.String() + out[index:]
+		out = out[:index] + converted + out[index:]
 	}
 	if err != ErrHTMLElementNotFound {
-		return "", err
+		return "", nil, err
 	}
 	// final conversion
+	source := []byte(out)
+	converted, doc, err := convert(source, resolve)
+	if err != nil {
+		return "", nil, err
+	}
+	return converted, buildTOC(doc, source), nil
+}
+
+// convert parses and renders the markdown, returning the html and the parsed document.
+func convert(source []byte, resolve LinkResolver) (string, ast.Node, error) {
+	ctx := parser.NewContext()
+	ctx.Set(linkResolverKey, resolve)
+	doc := md.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		return "", nil, err
+	}
+	return buf.String(), doc, nil
+}
+
+// buildTOC nests the headings of the document by level.
+func buildTOC(doc ast.Node, source []byte) []TOCEntry {
+	var headings []TOCEntry
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		heading, ok := node.(*ast.Heading)
+		if !ok {
+			continue
+		}
+		id, _ := heading.AttributeString("id")
+		idBytes, _ := id.([]byte)
+		headings = append(headings, TOCEntry{Level: heading.Level, ID: string(idBytes), Title: strings.TrimSpace(nodeText(heading, source))})
+	}
+	toc, _ := nestTOC(headings, 0, 0)
+	return toc
+}
+
+// nestTOC returns the entries starting at i that are nested under a heading with the given level, and the index after them.
+func nestTOC(headings []TOCEntry, i, parentLevel int) ([]TOCEntry, int) {
+	var entries []TOCEntry
+	for i < len(headings) && headings[i].Level > parentLevel {
+		entry := headings[i]
+		i++
+		entry.Children, i = nestTOC(headings, i, entry.Level)
+		entries = append(entries, entry)
+	}
+	return entries, i
+}
+
+// nodeText returns the plain text of a node and its children.
+func nodeText(node ast.Node, source []byte) string {
 	var buf bytes.Buffer
-	if err := md.Convert([]byte(out), &buf); err != nil {
-		return "", err
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		switch c := child.(type) {
+		case *ast.Text:
+			buf.Write(c.Segment.Value(source))
+			if c.SoftLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(c.Value)
+		default:
+			buf.WriteString(nodeText(child, source))
+		}
 	}
-	return buf.String(), nil
+	return buf.String()
 }
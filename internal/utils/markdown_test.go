@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Data-Corruption/blog"
+)
+
+func init() {
+	blog.Init("", blog.NONE) // consume log messages so they don't block once the buffer fills
+	InitMarkdownConverter()
+}
+
+func TestMdToHTMLTOC(t *testing.T) {
+	markdown := "# Title\n\nIntro\n\n## Setup *fast*\n\n### Install `cli`\n\n#### Deep\n\n## Usage\n\n## Usage\n\n<mdsrc>\n## Inside mdsrc\n</mdsrc>\n\n> ## Quoted\n"
+	_, toc, err := MdToHTML(markdown, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TOCEntry{
+		{Level: 1, ID: "title", Title: "Title", Children: []TOCEntry{
+			{Level: 2, ID: "setup-fast", Title: "Setup fast", Children: []TOCEntry{
+				{Level: 3, ID: "install-cli", Title: "Install cli", Children: []TOCEntry{
+					{Level: 4, ID: "deep", Title: "Deep"},
+				}},
+			}},
+			{Level: 2, ID: "usage", Title: "Usage"},
+			{Level: 2, ID: "usage-1", Title: "Usage"},
+		}},
+	}
+	if !reflect.DeepEqual(toc, want) {
+		t.Errorf("toc =\n%+v\nwant\n%+v", toc, want)
+	}
+}
+
+func TestNestTOC(t *testing.T) {
+	// pages can start below the top level or skip levels
+	headings := []TOCEntry{{Level: 3, ID: "a"}, {Level: 2, ID: "b"}, {Level: 4, ID: "c"}, {Level: 3, ID: "d"}, {Level: 1, ID: "e"}}
+	toc, next := nestTOC(headings, 0, 0)
+	want := []TOCEntry{
+		{Level: 3, ID: "a"},
+		{Level: 2, ID: "b", Children: []TOCEntry{{Level: 4, ID: "c"}, {Level: 3, ID: "d"}}},
+		{Level: 1, ID: "e"},
+	}
+	if next != len(headings) || !reflect.DeepEqual(toc, want) {
+		t.Errorf("nestTOC() = %+v, %d", toc, next)
+	}
+	if toc, _ := nestTOC(nil, 0, 0); toc != nil {
+		t.Errorf("nestTOC(nil) = %+v", toc)
+	}
+}
@@ -49,6 +49,16 @@ func ArgPresent(arg string) bool {
 	return false
 }
 
+// ArgValue returns the argument following the given one, e.g. "v1.2" for "-pin v1.2".
+func ArgValue(arg string) (string, bool) {
+	for i, a := range os.Args[:len(os.Args)-1] {
+		if a == arg {
+			return os.Args[i+1], true
+		}
+	}
+	return "", false
+}
+
 // GenRandomString generates a cryptographically secure random token of the given size.
 // Output is URL and filename safe.
 func GenRandomString(size int) (string, error) {
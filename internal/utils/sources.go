@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ContentSource is a content repository mounted into the site. Ids of its pages are namespaced as "name:id".
+// The main repository, content_repo, is the source with an empty name and mount.
+type ContentSource struct {
+	Name      string `json:"name"` // lowercase letters, digits, and dashes
+	URL       string `json:"url"`  // ssh or https clone url
+	Path      string `json:"path"` // local directory to read content from instead of cloning url, for development
+	Branch    string `json:"branch"`
+	AssetsDir string `json:"assets_dir"`
+	Mount     string `json:"mount"` // url prefix of its pages and assets, e.g. "blog" for "/p/blog/..." and "/blog/assets/..."
+}
+
+var (
+	sourceNameRegex  = regexp.MustCompile(`^[a-z0-9-]+$`)
+	sourceMountRegex = regexp.MustCompile(`^[a-z0-9-]+(/[a-z0-9-]+)*$`)
+	reservedMounts   = []string{"p", "page", "edit", "update", "css", "search"} // first segments taken by other routes
+)
+
+// ContentSources returns every content source, the main repository first. Empty branches and assets dirs get defaults.
+func (c *ImConfig) ContentSources() []ContentSource {
+	sources := []ContentSource{{URL: c.ContentRepo.URL, Path: c.ContentRepo.Path, Branch: c.ContentRepo.Branch, AssetsDir: c.ContentRepo.AssetsDir}}
+	for _, source := range c.Sources {
+		source.Branch = Ternary(source.Branch == "", "main", source.Branch)
+		source.AssetsDir = Ternary(source.AssetsDir == "", "assets", source.AssetsDir)
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// ContentSource returns the source with the given name, empty for the main repository.
+func (c *ImConfig) ContentSource(name string) (ContentSource, bool) {
+	for _, source := range c.ContentSources() {
+		if source.Name == name {
+			return source, true
+		}
+	}
+	return ContentSource{}, false
+}
+
+// HasRemoteSources returns true if any content source is cloned from its url rather than read from a local path.
+func (c *ImConfig) HasRemoteSources() bool {
+	for _, source := range c.ContentSources() {
+		if source.Path == "" && source.URL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSources returns an error if any of the additional sources has an invalid or duplicate name or mount.
+func (c *ImConfig) CheckSources() error {
+	names := make(map[string]bool)
+	mounts := make(map[string]bool)
+	for _, source := range c.Sources {
+		if !sourceNameRegex.MatchString(source.Name) {
+			return fmt.Errorf("invalid source name '%s', use lowercase letters, digits, and dashes", source.Name)
+		} else if names[source.Name] {
+			return fmt.Errorf("duplicate source name '%s'", source.Name)
+		}
+		names[source.Name] = true
+		if source.URL == "" && source.Path == "" {
+			return fmt.Errorf("source '%s' has no url or path", source.Name)
+		}
+		if !sourceMountRegex.MatchString(source.Mount) {
+			return fmt.Errorf("invalid mount '%s' for source '%s', use lowercase path segments like 'blog'", source.Mount, source.Name)
+		}
+		first, _, _ := strings.Cut(source.Mount, "/")
+		if Contains(first, reservedMounts) || first == c.ContentRepo.AssetsDir {
+			return fmt.Errorf("mount '%s' for source '%s' conflicts with a site route", source.Mount, source.Name)
+		} else if mounts[first] {
+			return fmt.Errorf("mount '%s' for source '%s' overlaps another source", source.Mount, source.Name)
+		}
+		mounts[first] = true
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentSources(t *testing.T) {
+	var c ImConfig
+	c.ContentRepo.URL, c.ContentRepo.Branch, c.ContentRepo.AssetsDir = "https://example.com/docs.git", "trunk", "static"
+	c.Sources = []ContentSource{{Name: "blog", URL: "https://example.com/blog.git", Mount: "blog"}}
+	sources := c.ContentSources()
+	if len(sources) != 2 || sources[0].Name != "" || sources[0].Branch != "trunk" || sources[0].AssetsDir != "static" {
+		t.Fatalf("sources = %+v", sources)
+	}
+	if sources[1].Branch != "main" || sources[1].AssetsDir != "assets" {
+		t.Errorf("defaults not applied: %+v", sources[1])
+	}
+	if source, ok := c.ContentSource("blog"); !ok || source.URL != "https://example.com/blog.git" {
+		t.Errorf("ContentSource(blog) = %+v, %v", source, ok)
+	}
+	if _, ok := c.ContentSource("missing"); ok {
+		t.Error("found a missing source")
+	}
+}
+
+func TestHasRemoteSources(t *testing.T) {
+	var c ImConfig
+	c.ContentRepo.Path = "/srv/docs"
+	if c.HasRemoteSources() {
+		t.Error("local main repository is remote")
+	}
+	c.Sources = []ContentSource{{Name: "blog", URL: "https://example.com/blog.git", Path: "/srv/blog"}}
+	if c.HasRemoteSources() {
+		t.Error("source with a path is remote")
+	}
+	c.Sources = append(c.Sources, ContentSource{Name: "news", URL: "https://example.com/news.git"})
+	if !c.HasRemoteSources() {
+		t.Error("cloned source isn't remote")
+	}
+}
+
+func TestCheckSources(t *testing.T) {
+	tests := []struct {
+		sources []ContentSource
+		err     string // part of the error, empty if valid
+	}{
+		{nil, ""},
+		{[]ContentSource{{Name: "blog", URL: "u", Mount: "blog"}, {Name: "api-v2", Path: "p", Mount: "docs/api"}}, ""},
+		{[]ContentSource{{Name: "Blog", URL: "u", Mount: "blog"}}, "invalid source name"},
+		{[]ContentSource{{Name: "blog", URL: "u", Mount: "blog"}, {Name: "blog", URL: "u", Mount: "news"}}, "duplicate source name"},
+		{[]ContentSource{{Name: "blog", Mount: "blog"}}, "no url or path"},
+		{[]ContentSource{{Name: "blog", URL: "u", Mount: ""}}, "invalid mount"},
+		{[]ContentSource{{Name: "blog", URL: "u", Mount: "/blog/"}}, "invalid mount"},
+		{[]ContentSource{{Name: "blog", URL: "u", Mount: "p/blog"}}, "conflicts with a site route"},
+		{[]ContentSource{{Name: "blog", URL: "u", Mount: "assets"}}, "conflicts with a site route"},
+		{[]ContentSource{{Name: "blog", URL: "u", Mount: "docs/blog"}, {Name: "api", URL: "u", Mount: "docs/api"}}, "overlaps another source"},
+	}
+	for _, test := range tests {
+		c := ImConfig{Sources: test.sources}
+		c.ContentRepo.AssetsDir = "assets"
+		err := c.CheckSources()
+		if test.err == "" && err != nil {
+			t.Errorf("CheckSources(%+v) = %v", test.sources, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("CheckSources(%+v) = %v, want %q", test.sources, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/blog"
+)
+
+// webhook events
+const (
+	EVENT_UPDATE_SUCCESS = "update_success"
+	EVENT_UPDATE_FAILURE = "update_failure"
+	EVENT_MISSING_FILES  = "missing_files"
+	EVENT_ID_PROBLEMS    = "id_problems"
+	EVENT_ORPHANED_ITEMS = "orphaned_items"
+	EVENT_BROKEN_LINKS   = "broken_links"
+	EVENT_FRONT_MATTER   = "front_matter"
+)
+
+// webhook formats
+const (
+	WEBHOOK_FORMAT_JSON    = "json"
+	WEBHOOK_FORMAT_DISCORD = "discord"
+	WEBHOOK_FORMAT_SLACK   = "slack"
+)
+
+const (
+	WEBHOOK_ATTEMPTS      = 4  // tries per delivery before giving up
+	WEBHOOK_MAX_DETAILS   = 20 // details listed in chat messages, the rest are summarized
+	DISCORD_MAX_CONTENT   = 2000
+	webhookRequestTimeout = 10 * time.Second
+)
+
+// WebhookRetryDelay is the wait before the first retry, doubled after each failed attempt.
+var WebhookRetryDelay = 2 * time.Second
+
+// WebhookTarget is an endpoint notified of events. An empty event list subscribes to every event.
+type WebhookTarget struct {
+	URL    string   `json:"url"`
+	Format string   `json:"format"` // "json", "discord", or "slack"
+	Events []string `json:"events"`
+}
+
+// WebhookEvent is the message sent to webhook targets. The generic json format posts it as is.
+type WebhookEvent struct {
+	Event   string    `json:"event"`
+	Site    string    `json:"site"`
+	Message string    `json:"message"`
+	Details []string  `json:"details,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Wants returns true if the target is subscribed to the event.
+func (t *WebhookTarget) Wants(event string) bool {
+	return len(t.Events) == 0 || Contains(event, t.Events)
+}
+
+// NotifyWebhooks sends the event to every subscribed target in the background.
+func NotifyWebhooks(event string, message string, details []string) {
+	e := WebhookEvent{Event: event, Site: Config.Title, Message: message, Details: details, Time: time.Now()}
+	for _, target := range Config.Webhooks {
+		if !target.Wants(event) {
+			continue
+		}
+		go func(target WebhookTarget) {
+			if err := DeliverWebhook(target, e); err != nil {
+				blog.Errorf("Error delivering '%s' webhook to %s: %v", event, target.URL, err)
+			}
+		}(target)
+	}
+}
+
+// DeliverWebhook posts the event to the target, retrying with backoff on network errors, 429s, and 5xx responses.
+func DeliverWebhook(target WebhookTarget, event WebhookEvent) error {
+	body, err := webhookPayload(target.Format, event)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookRequestTimeout}
+	delay := WebhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(client, target.URL, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == WEBHOOK_ATTEMPTS {
+			return fmt.Errorf("attempt %d: %w", attempt, err)
+		}
+		blog.Warnf("Webhook to %s failed, retrying in %s: %v", target.URL, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postWebhook makes a single delivery attempt. Returns true if a failure is worth retrying.
+func postWebhook(client *http.Client, url string, body []byte) (bool, error) {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("received %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+}
+
+// webhookPayload marshals the event into the body for the given format.
+func webhookPayload(format string, event WebhookEvent) ([]byte, error) {
+	switch format {
+	case "", WEBHOOK_FORMAT_JSON:
+		return marshalPayload(event)
+	case WEBHOOK_FORMAT_DISCORD:
+		text := webhookText(event, "**")
+		if runes := []rune(text); len(runes) > DISCORD_MAX_CONTENT {
+			text = string(runes[:DISCORD_MAX_CONTENT-1]) + "…"
+		}
+		return marshalPayload(map[string]string{"content": text})
+	case WEBHOOK_FORMAT_SLACK:
+		return marshalPayload(map[string]string{"text": webhookText(event, "*")})
+	default:
+		return nil, fmt.Errorf("unknown webhook format '%s'", format)
+	}
+}
+
+// marshalPayload is json.Marshal without escaping html characters, messages aren't embedded in html.
+func marshalPayload(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// webhookText formats the event as a chat message, bold is the markup for bold text.
+func webhookText(event WebhookEvent, bold string) string {
+	var sb strings.Builder
+	sb.WriteString(bold + event.Site + bold + ": " + event.Message)
+	for i, detail := range event.Details {
+		if i == WEBHOOK_MAX_DETAILS {
+			sb.WriteString(fmt.Sprintf("\n…and %d more", len(event.Details)-i))
+			break
+		}
+		sb.WriteString("\n• " + detail)
+	}
+	return sb.String()
+}
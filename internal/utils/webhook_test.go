@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer responds with the given statuses in order, repeating the last one, and records the bodies it receives.
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mutex sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		status := statuses[min(len(bodies), len(statuses)-1)]
+		bodies = append(bodies, string(body))
+		mutex.Unlock()
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), bodies...)
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	delay := WebhookRetryDelay
+	WebhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { WebhookRetryDelay = delay })
+
+	event := WebhookEvent{Event: EVENT_UPDATE_SUCCESS, Site: "Docs", Message: "Content updated", Time: time.Now()}
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		err      bool
+	}{
+		{"success", []int{http.StatusNoContent}, 1, false},
+		{"retry on 5xx", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, 3, false},
+		{"retry on 429", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"no retry on other 4xx", []int{http.StatusNotFound, http.StatusOK}, 1, true},
+		{"no retry on 400", []int{http.StatusBadRequest}, 1, true},
+		{"gives up", []int{http.StatusServiceUnavailable}, WEBHOOK_ATTEMPTS, true},
+	}
+	for _, test := range tests {
+		server, bodies := webhookServer(t, test.statuses...)
+		err := DeliverWebhook(WebhookTarget{URL: server.URL}, event)
+		if (err != nil) != test.err {
+			t.Errorf("%s: err = %v", test.name, err)
+		}
+		if got := len(bodies()); got != test.attempts {
+			t.Errorf("%s: %d attempts, want %d", test.name, got, test.attempts)
+		}
+	}
+
+	// network errors are retried too
+	server, _ := webhookServer(t, http.StatusOK)
+	server.Close()
+	if err := DeliverWebhook(WebhookTarget{URL: server.URL}, event); err == nil || !strings.Contains(err.Error(), "attempt 4") {
+		t.Errorf("unreachable target: err = %v", err)
+	}
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	delay := WebhookRetryDelay
+	WebhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { WebhookRetryDelay = delay })
+
+	event := WebhookEvent{Event: EVENT_BROKEN_LINKS, Site: "Docs", Message: "2 links <broken>", Details: []string{"a.md -> x", "b.md -> y"}, Time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		format string
+		want   map[string]interface{}
+	}{
+		{"", map[string]interface{}{"event": "broken_links", "site": "Docs", "message": "2 links <broken>", "details": []interface{}{"a.md -> x", "b.md -> y"}, "time": "2024-03-01T00:00:00Z"}},
+		{WEBHOOK_FORMAT_JSON, map[string]interface{}{"event": "broken_links", "site": "Docs", "message": "2 links <broken>", "details": []interface{}{"a.md -> x", "b.md -> y"}, "time": "2024-03-01T00:00:00Z"}},
+		{WEBHOOK_FORMAT_DISCORD, map[string]interface{}{"content": "**Docs**: 2 links <broken>\n• a.md -> x\n• b.md -> y"}},
+		{WEBHOOK_FORMAT_SLACK, map[string]interface{}{"text": "*Docs*: 2 links <broken>\n• a.md -> x\n• b.md -> y"}},
+	}
+	for _, test := range tests {
+		server, bodies := webhookServer(t, http.StatusOK)
+		if err := DeliverWebhook(WebhookTarget{URL: server.URL, Format: test.format}, event); err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(bodies()[0]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("format %q: payload = %v, want %v", test.format, got, test.want)
+		}
+	}
+	if err := DeliverWebhook(WebhookTarget{URL: "http://127.0.0.1:0", Format: "teams"}, event); err == nil {
+		t.Error("unknown format accepted")
+	}
+
+	// long chat messages are cut short
+	event.Details = make([]string, WEBHOOK_MAX_DETAILS+5)
+	for i := range event.Details {
+		event.Details[i] = strings.Repeat("x", 200)
+	}
+	body, err := webhookPayload(WEBHOOK_FORMAT_SLACK, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `…and 5 more`) {
+		t.Errorf("slack payload not summarized: %s", body)
+	}
+	if body, err = webhookPayload(WEBHOOK_FORMAT_DISCORD, event); err != nil {
+		t.Fatal(err)
+	}
+	var discord map[string]string
+	if err := json.Unmarshal(body, &discord); err != nil {
+		t.Fatal(err)
+	}
+	if runes := []rune(discord["content"]); len(runes) != DISCORD_MAX_CONTENT || !strings.HasSuffix(discord["content"], "…") {
+		t.Errorf("discord content is %d characters", len(runes))
+	}
+}
+
+func TestWebhookTargetWants(t *testing.T) {
+	all := WebhookTarget{}
+	some := WebhookTarget{Events: []string{EVENT_UPDATE_FAILURE}}
+	if !all.Wants(EVENT_BROKEN_LINKS) || !some.Wants(EVENT_UPDATE_FAILURE) || some.Wants(EVENT_UPDATE_SUCCESS) {
+		t.Error("unexpected Wants()")
+	}
+}